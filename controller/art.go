@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/hex"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"tinygo.org/x/drivers/pixel"
+)
+
+// The cover is drawn as a side panel right of the track and artist lines,
+// the text under it stays hidden.
+const (
+	ART_MAX_SIZE = 96
+	ART_Y        = 40
+	ART_MARGIN   = 4
+)
+
+type Art struct {
+	Image     pixel.Image[pixel.RGB565BE]
+	Size      int
+	Receiving bool
+	Shown     bool
+}
+
+var art = &Art{}
+
+// beginArt starts receiving a "<size>x<size>" RGB565 cover.
+func beginArt(content string) {
+	width, height, _ := strings.Cut(strings.TrimSpace(content), "x")
+	size, err := strconv.Atoi(width)
+	if err != nil || width != height || size <= 0 || size > ART_MAX_SIZE {
+		art.Receiving = false
+		return
+	}
+
+	if art.Size != size {
+		clearArt()
+		art.Image = pixel.NewImage[pixel.RGB565BE](size, size)
+		art.Size = size
+	}
+	art.Receiving = true
+}
+
+// receiveArt copies an "<offset>:<hex>" chunk of pixel data.
+func receiveArt(content string) {
+	if !art.Receiving {
+		return
+	}
+
+	offset, data, ok := strings.Cut(strings.TrimSpace(content), ":")
+	start, err := strconv.Atoi(offset)
+	if !ok || err != nil || start < 0 {
+		return
+	}
+
+	buffer := art.Image.RawBuffer()
+	if start+len(data)/2 > len(buffer) {
+		return
+	}
+	hex.Decode(buffer[start:], []byte(data))
+}
+
+func endArt() {
+	if !art.Receiving {
+		return
+	}
+
+	art.Receiving = false
+	art.Shown = true
+	drawArt()
+}
+
+func drawArt() {
+	if art.Shown {
+		display.DrawBitmap(int16(LCD_WIDTH-ART_MARGIN-art.Size), ART_Y, art.Image)
+	}
+}
+
+// clearArt removes the cover and redraws the text it covered.
+func clearArt() {
+	if !art.Shown {
+		return
+	}
+
+	art.Shown = false
+	display.FillRectangle(int16(LCD_WIDTH-ART_MARGIN-art.Size), ART_Y, int16(art.Size), int16(art.Size), color.RGBA{0, 0, 0, 255})
+	clearAndRenderTrack(displayState.Track)
+	clearAndRenderArtist(displayState.Artist)
+	clearAndRenderAlbum(displayState.Album)
+}
@@ -77,6 +77,10 @@ func listenDisplayCommands(displayCommands chan *DisplayCommand) {
 
 				msgBuffer = nil
 			}
+
+			// Keep reading while there is input, covers come in many
+			// long commands.
+			continue
 		}
 		time.Sleep(1 * time.Millisecond)
 	}
@@ -119,6 +123,18 @@ func renderDisplayCommand(section string, content string) {
 			clearAndRenderTime(content)
 		}
 
+	case "art_begin":
+		beginArt(content)
+
+	case "art":
+		receiveArt(content)
+
+	case "art_end":
+		endArt()
+
+	case "art_clear":
+		clearArt()
+
 	case "player_status":
 		if displayState.PlayerStatus != content {
 			displayState.PlayerStatus = content
@@ -130,16 +146,19 @@ func renderDisplayCommand(section string, content string) {
 func clearAndRenderTrack(track string) {
 	display.FillRectangle(0, 40, 240, 36, color.RGBA{0, 0, 0, 255})
 	tinyfont.WriteLine(&display, &freesans.Regular12pt7b, 12, 64, track, color.RGBA{255, 255, 255, 255})
+	drawArt()
 }
 
 func clearAndRenderArtist(artist string) {
 	display.FillRectangle(0, 76, 240, 36, color.RGBA{0, 0, 0, 255})
 	tinyfont.WriteLine(&display, &freesans.Regular12pt7b, 12, 100, artist, color.RGBA{255, 255, 255, 255})
+	drawArt()
 }
 
 func clearAndRenderAlbum(album string) {
 	display.FillRectangle(0, 112, 240, 36, color.RGBA{0, 0, 0, 255})
 	tinyfont.WriteLine(&display, &freesans.Regular12pt7b, 12, 136, album, color.RGBA{255, 255, 255, 255})
+	drawArt()
 }
 
 func clearAndRenderTime(time string) {
@@ -183,4 +202,9 @@ func clearAndRenderButtonCues() {
 //artist|Unknown Artist
 //track|3. Unknown Track
 //time|[00:00/04:00]
+//time|3.2 01:23/04:00
 //player_status|Playing
+//art_begin|72x72
+//art|0:f800f800...
+//art_end|
+//art_clear|
@@ -6,12 +6,15 @@ import (
 )
 
 const (
-	debounceTime = 250 * time.Millisecond
-	tickInterval = 13 * time.Millisecond
+	debounceTime  = 250 * time.Millisecond
+	longPressTime = 1 * time.Second
+	tickInterval  = 13 * time.Millisecond
 )
 
 var (
 	lastKeyPress time.Time
+	pressedSince = map[string]time.Time{}
+	longPressed  = map[string]bool{}
 )
 
 var KeyMap = map[string]machine.Pin{
@@ -21,17 +24,22 @@ var KeyMap = map[string]machine.Pin{
 	"Eject":      machine.GP21, // Y
 }
 
+type KeyEvent struct {
+	Event string
+	Key   string
+}
+
 func initKeys() {
 	for _, pin := range KeyMap {
 		pin.Configure(machine.PinConfig{Mode: machine.PinInputPullup})
 	}
 }
 
-func listenKeys(keyPresses chan string) {
+func listenKeys(keyEvents chan *KeyEvent) {
 	for {
 		for keyCode, key := range KeyMap {
-			if checkKey(key) {
-				keyPresses <- keyCode
+			if event := checkKey(keyCode, key); event != "" {
+				keyEvents <- &KeyEvent{Event: event, Key: keyCode}
 			}
 		}
 
@@ -39,11 +47,32 @@ func listenKeys(keyPresses chan string) {
 	}
 }
 
-func checkKey(key machine.Pin) bool {
-	if !key.Get() && time.Since(lastKeyPress) > debounceTime {
-		lastKeyPress = time.Now()
-		return true
+// checkKey reports a "keypress" when a key is released, or a "longpress"
+// as soon as it has been held for longPressTime.
+func checkKey(keyCode string, key machine.Pin) string {
+	pressed := !key.Get()
+	since, down := pressedSince[keyCode]
+
+	switch {
+	case pressed && !down:
+		pressedSince[keyCode] = time.Now()
+
+	case pressed && !longPressed[keyCode] && time.Since(since) > longPressTime:
+		longPressed[keyCode] = true
+		return "longpress"
+
+	case !pressed && down:
+		delete(pressedSince, keyCode)
+		if longPressed[keyCode] {
+			longPressed[keyCode] = false
+			return ""
+		}
+
+		if time.Since(lastKeyPress) > debounceTime {
+			lastKeyPress = time.Now()
+			return "keypress"
+		}
 	}
 
-	return false
+	return ""
 }
@@ -19,16 +19,16 @@ func main() {
 	displayHeaderWithInfo("Waiting Player...")
 	clearAndRenderButtonCues()
 
-	keyPresses := make(chan string)
-	go listenKeys(keyPresses)
+	keyEvents := make(chan *KeyEvent)
+	go listenKeys(keyEvents)
 
 	displayCommands := make(chan *DisplayCommand)
 	go listenDisplayCommands(displayCommands)
 
 	for {
 		select {
-		case keyPress := <-keyPresses:
-			println(`{"event": "keypress", "key": "` + keyPress + `"}`)
+		case keyEvent := <-keyEvents:
+			println(`{"event": "` + keyEvent.Event + `", "key": "` + keyEvent.Key + `"}`)
 
 		case displayCommand := <-displayCommands:
 			if displayCommand != nil {
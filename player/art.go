@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxArtSize is the largest cover the controller can buffer.
+	maxArtSize = 96
+
+	// artChunkSize is the number of pixel bytes sent per command.
+	artChunkSize = 128
+
+	// artMaxReleases limits the releases asked for a cover, the disc's
+	// releases usually share one.
+	artMaxReleases = 3
+)
+
+// Artwork is the cover of a disc as an RGB565 thumbnail, no thumbnail
+// means the disc has none.
+type Artwork struct {
+	DiscID    string
+	Source    string
+	Size      int
+	Thumbnail []byte // big endian RGB565, row by row
+}
+
+// CoverArt finds covers in the local art directory or on the Cover Art
+// Archive. Downloaded covers are kept on disk, an empty file records a
+// release without one.
+type CoverArt struct {
+	config    ArtConfig
+	cacheDir  string
+	userAgent string
+	client    *http.Client
+}
+
+func NewCoverArt(config ArtConfig, musicBrainz MusicBrainzConfig) (*CoverArt, error) {
+	cacheDir := config.CacheDir
+	if cacheDir == "" {
+		dir, err := stateDir("")
+		if err != nil {
+			return nil, err
+		}
+		cacheDir = filepath.Join(dir, "art")
+	}
+
+	err := os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create art cache directory: %w", err)
+	}
+
+	// Same proxy and user agent as for MusicBrainz.
+	mb := NewMusicBrainzClient(musicBrainz)
+
+	return &CoverArt{
+		config:    config,
+		cacheDir:  cacheDir,
+		userAgent: mb.userAgent,
+		client:    &http.Client{Timeout: config.Timeout, Transport: mb.client.Transport},
+	}, nil
+}
+
+// Load returns the cover of a disc, nil when there is none. The local
+// folder.jpg goes first, then the selected release and the other
+// MusicBrainz candidates.
+func (a *CoverArt) Load(ctx context.Context, disc *Disc) (*Artwork, error) {
+	artwork := &Artwork{DiscID: disc.ID, Size: a.config.Size}
+
+	if a.config.Dir != "" {
+		path := filepath.Join(a.config.Dir, disc.ID, "folder.jpg")
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = artwork.decode(path, data)
+			if err != nil {
+				return nil, err
+			}
+			return artwork, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read cover: %w", err)
+		}
+	}
+
+	var releases []string
+	candidates := disc.Candidates
+	if selected := disc.SelectedCandidate(); selected != nil {
+		candidates = append([]*ReleaseCandidate{selected}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if candidate.Provider == "musicbrainz" && candidate.ReleaseID != "" && !slices.Contains(releases, candidate.ReleaseID) {
+			releases = append(releases, candidate.ReleaseID)
+		}
+	}
+
+	for i, release := range releases {
+		if i >= artMaxReleases {
+			break
+		}
+
+		data, err := a.release(ctx, release)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			err = artwork.decode("release "+release, data)
+			if err != nil {
+				return nil, err
+			}
+			return artwork, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (a *Artwork) decode(source string, data []byte) error {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode cover from %s: %w", source, err)
+	}
+
+	a.Source = source
+	a.Thumbnail = thumbnail(img, a.Size)
+
+	return nil
+}
+
+// release returns the front cover of a release from the disk cache or the
+// Cover Art Archive, empty when it has none.
+func (a *CoverArt) release(ctx context.Context, releaseID string) ([]byte, error) {
+	path := filepath.Join(a.cacheDir, releaseID)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return data, nil
+	}
+
+	// The 250px thumbnail is plenty for the controller.
+	url := strings.TrimSuffix(a.config.URL, "/") + "/release/" + releaseID + "/front-250"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", a.userAgent)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err = io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cover: %w", err)
+		}
+	case http.StatusNotFound:
+		data = nil
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		fmt.Printf("Failed to cache cover of release %s: %v\n", releaseID, err)
+	}
+
+	return data, nil
+}
+
+// thumbnail crops the middle square of an image, scales it down to size by
+// averaging and dithers it to RGB565 with Floyd-Steinberg error diffusion.
+func thumbnail(img image.Image, size int) []byte {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	left := bounds.Min.X + (bounds.Dx()-side)/2
+	top := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	pixels := make([][3]float64, size*size)
+	for y := 0; y < size; y++ {
+		y0, y1 := top+y*side/size, top+max((y+1)*side/size, y*side/size+1)
+		for x := 0; x < size; x++ {
+			x0, x1 := left+x*side/size, left+max((x+1)*side/size, x*side/size+1)
+
+			var sum [3]float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum[0] += float64(r >> 8)
+					sum[1] += float64(g >> 8)
+					sum[2] += float64(b >> 8)
+				}
+			}
+
+			n := float64((y1 - y0) * (x1 - x0))
+			pixels[y*size+x] = [3]float64{sum[0] / n, sum[1] / n, sum[2] / n}
+		}
+	}
+
+	spread := func(x int, y int, quantErr [3]float64, weight float64) {
+		if x < 0 || x >= size || y >= size {
+			return
+		}
+		for c := range quantErr {
+			pixels[y*size+x][c] += quantErr[c] * weight
+		}
+	}
+
+	levels := [3]float64{31, 63, 31}
+	out := make([]byte, 0, size*size*2)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var q [3]int
+			var quantErr [3]float64
+			for c, value := range pixels[y*size+x] {
+				q[c] = int(min(max(value*levels[c]/255+0.5, 0), levels[c]))
+				quantErr[c] = value - float64(q[c])*255/levels[c]
+			}
+
+			spread(x+1, y, quantErr, 7.0/16)
+			spread(x-1, y+1, quantErr, 3.0/16)
+			spread(x, y+1, quantErr, 5.0/16)
+			spread(x+1, y+1, quantErr, 1.0/16)
+
+			rgb565 := q[0]<<11 | q[1]<<5 | q[2]
+			out = append(out, byte(rgb565>>8), byte(rgb565))
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,43 @@
+package main
+
+type BackendEventType int
+
+const (
+	PositionChanged BackendEventType = iota
+	PauseChanged
+	ChapterChanged
+	MediaLoaded
+	PlaybackRestarted // playback resumed after loading or seeking
+	EndOfMedia
+)
+
+type BackendEvent struct {
+	Type BackendEventType
+
+	Position int // in ms, for PositionChanged
+	Paused   bool
+	Chapter  int // -1 when unknown
+}
+
+// AudioBackend is everything the Player needs from whatever is producing
+// sound. Chapters map one-to-one to the tracks of the loaded disc, a
+// loaded file has none.
+type AudioBackend interface {
+	LoadDisc() error
+	LoadFile(path string) error
+	Play() error
+	Pause() error
+	Stop() error
+
+	Seek(position int) error // in ms
+	Position() (int, error)  // in ms
+
+	NextChapter() error
+	PreviousChapter() error
+	SetChapter(chapter int) error
+
+	SetVolume(volume int) error // 0-100
+	Volume() (int, error)
+
+	Events() <-chan BackendEvent
+}
@@ -0,0 +1,144 @@
+package main
+
+import "fmt"
+
+// FakeBackend is an in-memory AudioBackend. It plays nothing, it only keeps
+// the state the Player asked for and reports changes on Events like mpv
+// would, so the player can run without mpv or a drive.
+type FakeBackend struct {
+	Loaded     bool
+	File       string // set while a file is loaded instead of the disc
+	Paused     bool
+	Chapter    int
+	Chapters   []int // chapter start positions in ms
+	PositionMs int
+	Level      int
+
+	events chan BackendEvent
+}
+
+var _ AudioBackend = (*FakeBackend)(nil)
+
+func NewFakeBackend(chapters []int) *FakeBackend {
+	return &FakeBackend{
+		Chapter:  -1,
+		Chapters: chapters,
+		Level:    100,
+		events:   make(chan BackendEvent, 64),
+	}
+}
+
+func (f *FakeBackend) Emit(event BackendEvent) {
+	select {
+	case f.events <- event:
+	default:
+	}
+}
+
+func (f *FakeBackend) LoadDisc() error {
+	f.Loaded = true
+	f.File = ""
+	f.Paused = false
+	f.Emit(BackendEvent{Type: MediaLoaded})
+
+	if len(f.Chapters) == 0 {
+		return nil
+	}
+
+	return f.SetChapter(0)
+}
+
+func (f *FakeBackend) LoadFile(path string) error {
+	f.Loaded = true
+	f.File = path
+	f.Paused = false
+	f.Chapter = -1
+	f.PositionMs = 0
+	f.Emit(BackendEvent{Type: MediaLoaded})
+	f.Emit(BackendEvent{Type: ChapterChanged, Chapter: -1})
+	f.Emit(BackendEvent{Type: PlaybackRestarted})
+
+	return nil
+}
+
+func (f *FakeBackend) Play() error {
+	f.Paused = false
+	f.Emit(BackendEvent{Type: PauseChanged, Paused: false})
+	return nil
+}
+
+func (f *FakeBackend) Pause() error {
+	f.Paused = true
+	f.Emit(BackendEvent{Type: PauseChanged, Paused: true})
+	return nil
+}
+
+func (f *FakeBackend) Stop() error {
+	f.Loaded = false
+	f.Chapter = -1
+	f.PositionMs = 0
+	f.Emit(BackendEvent{Type: ChapterChanged, Chapter: -1})
+	return nil
+}
+
+func (f *FakeBackend) Seek(position int) error {
+	f.PositionMs = position
+	f.Emit(BackendEvent{Type: PositionChanged, Position: position})
+	f.Emit(BackendEvent{Type: PlaybackRestarted})
+
+	chapter := -1
+	for i, start := range f.Chapters {
+		if position >= start {
+			chapter = i
+		}
+	}
+
+	if chapter != f.Chapter {
+		f.Chapter = chapter
+		f.Emit(BackendEvent{Type: ChapterChanged, Chapter: chapter})
+	}
+
+	return nil
+}
+
+func (f *FakeBackend) Position() (int, error) {
+	return f.PositionMs, nil
+}
+
+func (f *FakeBackend) NextChapter() error {
+	if f.Chapter+1 >= len(f.Chapters) {
+		f.Emit(BackendEvent{Type: EndOfMedia})
+		return f.Stop()
+	}
+
+	return f.SetChapter(f.Chapter + 1)
+}
+
+func (f *FakeBackend) PreviousChapter() error {
+	if f.Chapter <= 0 {
+		return f.SetChapter(0)
+	}
+
+	return f.SetChapter(f.Chapter - 1)
+}
+
+func (f *FakeBackend) SetChapter(chapter int) error {
+	if chapter < 0 || chapter >= len(f.Chapters) {
+		return fmt.Errorf("no such chapter: %d", chapter)
+	}
+
+	return f.Seek(f.Chapters[chapter])
+}
+
+func (f *FakeBackend) SetVolume(volume int) error {
+	f.Level = volume
+	return nil
+}
+
+func (f *FakeBackend) Volume() (int, error) {
+	return f.Level, nil
+}
+
+func (f *FakeBackend) Events() <-chan BackendEvent {
+	return f.events
+}
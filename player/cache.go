@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type CacheEntry struct {
+	Disc      *Disc     `json:"disc"`
+	FetchedAt time.Time `json:"fetched_at"`
+	UsedAt    time.Time `json:"used_at"`
+}
+
+// MetadataCache keeps resolved disc metadata on disk, keyed by MusicBrainz
+// disc ID. Entries older than the TTL are still served but should be
+// refreshed, the least recently used entries are dropped above maxEntries.
+type MetadataCache struct {
+	path       string
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*CacheEntry
+	// dirty is set when access times changed since the last save, reads
+	// write them at most once per cacheSaveInterval.
+	dirty   bool
+	savedAt time.Time
+}
+
+// cacheSaveInterval limits how often cache reads write the file, which is
+// on an SD card on most boards.
+const cacheSaveInterval = 10 * time.Minute
+
+var _ MetadataProvider = (*MetadataCache)(nil)
+
+func OpenMetadataCache(config CacheConfig) (*MetadataCache, error) {
+	dir, err := stateDir(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "metadata.json")
+	c := &MetadataCache{
+		path:       path,
+		ttl:        config.TTL,
+		maxEntries: config.MaxEntries,
+		entries:    make(map[string]*CacheEntry),
+		savedAt:    time.Now(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata cache: %w", err)
+	}
+
+	err = json.Unmarshal(data, &c.entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metadata cache %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Get returns a copy of the cached disc and whether it is still fresh.
+func (c *MetadataCache) Get(discID string) (disc *Disc, fresh bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[discID]
+	if !ok {
+		return nil, false, false
+	}
+
+	entry.UsedAt = time.Now()
+	c.dirty = true
+	if time.Since(c.savedAt) >= cacheSaveInterval {
+		err := c.save()
+		if err != nil {
+			fmt.Printf("Failed to save metadata cache: %v\n", err)
+		}
+	}
+
+	return entry.Disc.Copy(), time.Since(entry.FetchedAt) < c.ttl, true
+}
+
+func (c *MetadataCache) Name() string {
+	return "cache"
+}
+
+// Lookup returns the candidates the disc was identified with, the one that
+// was selected first. Discs cached without them, e.g. imported ones, are a
+// single candidate.
+func (c *MetadataCache) Lookup(ctx context.Context, query *DiscQuery) ([]*ReleaseCandidate, error) {
+	disc, fresh, ok := c.Get(query.ID)
+	if !ok || len(disc.Tracks) != query.Tracks {
+		return nil, nil
+	}
+
+	var candidates []*ReleaseCandidate
+	if selected := disc.SelectedCandidate(); selected != nil {
+		candidates = append(candidates, selected)
+		for i, candidate := range disc.Candidates {
+			if i != disc.Candidate {
+				candidates = append(candidates, candidate)
+			}
+		}
+	} else {
+		candidate := &ReleaseCandidate{
+			Provider:    c.Name(),
+			Confidence:  1,
+			Approximate: disc.Approximate,
+			Title:       disc.Title,
+			Artist:      disc.Artist,
+			Year:        disc.Year,
+			Media:       disc.Media,
+
+			Label:          disc.Label,
+			CatalogNumber:  disc.CatalogNumber,
+			Disambiguation: disc.Disambiguation,
+		}
+		for _, track := range disc.Tracks {
+			candidate.Tracks = append(candidate.Tracks, TrackMetadata{
+				Title:             track.Title,
+				Artist:            track.Artist,
+				Recording:         track.Recording,
+				ClassicalMetadata: track.ClassicalMetadata,
+			})
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	for _, candidate := range candidates {
+		candidate.Stale = !fresh
+		candidate.Cached = true
+	}
+
+	return candidates, nil
+}
+
+func (c *MetadataCache) Put(disc *Disc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.entries[disc.ID] = &CacheEntry{
+		Disc:      disc.Copy(),
+		FetchedAt: now,
+		UsedAt:    now,
+	}
+
+	for len(c.entries) > c.maxEntries {
+		var oldest string
+		for id, entry := range c.entries {
+			if oldest == "" || entry.UsedAt.Before(c.entries[oldest].UsedAt) {
+				oldest = id
+			}
+		}
+		delete(c.entries, oldest)
+	}
+
+	return c.save()
+}
+
+func (c *MetadataCache) Entry(discID string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[discID]
+	return entry, ok
+}
+
+// List returns all entries, most recently used first.
+func (c *MetadataCache) List() []*CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]*CacheEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UsedAt.After(entries[j].UsedAt)
+	})
+
+	return entries
+}
+
+func (c *MetadataCache) Delete(discID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[discID]; !ok {
+		return fmt.Errorf("disc %s is not cached", discID)
+	}
+
+	delete(c.entries, discID)
+	return c.save()
+}
+
+func (c *MetadataCache) Purge() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*CacheEntry)
+	return c.save()
+}
+
+// Flush writes access times that reads have not saved yet.
+func (c *MetadataCache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+	return c.save()
+}
+
+// save writes the cache through a temporary file so a crash never leaves
+// a truncated cache behind. Must be called with c.mu held.
+func (c *MetadataCache) save() error {
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write metadata cache: %w", err)
+	}
+
+	err = os.Rename(tmp, c.path)
+	if err != nil {
+		return fmt.Errorf("failed to write metadata cache: %w", err)
+	}
+
+	c.dirty = false
+	c.savedAt = time.Now()
+	return nil
+}
+
+// stateDir returns the directory for data that should survive restarts,
+// $XDG_STATE_HOME/oscdp or ~/.local/state/oscdp unless configured.
+func stateDir(dir string) (string, error) {
+	if dir == "" {
+		base := os.Getenv("XDG_STATE_HOME")
+		if base == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("failed to find state directory: %w", err)
+			}
+			base = filepath.Join(home, ".local", "state")
+		}
+		dir = filepath.Join(base, "oscdp")
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestCache(t *testing.T, ttl time.Duration) *MetadataCache {
+	t.Helper()
+
+	cache, err := OpenMetadataCache(CacheConfig{Dir: t.TempDir(), TTL: ttl, MaxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cache
+}
+
+func TestCacheHitsAreTagged(t *testing.T) {
+	cache := openTestCache(t, time.Hour)
+
+	musicBrainz := NewFakeMetadataProvider("musicbrainz")
+	disc := testDisc(t)
+	disc.ID = "test-disc"
+	musicBrainz.Add(disc.ID, &ReleaseCandidate{
+		ReleaseID: "release",
+		Title:     "Album",
+		Artist:    "Artist",
+		Tracks:    []TrackMetadata{{Title: "One"}, {Title: "Two"}, {Title: "Three"}},
+	})
+
+	chain := NewMetadataChainWith(cache, cache, musicBrainz)
+
+	first := disc.Copy()
+	chain.Identify(context.Background(), first)
+	if first.Sources["title"] != "musicbrainz" {
+		t.Errorf("looked up title from %q, want musicbrainz", first.Sources["title"])
+	}
+
+	second := disc.Copy()
+	chain.Identify(context.Background(), second)
+	if len(musicBrainz.Queries) != 1 {
+		t.Errorf("MusicBrainz was asked %d times, want once", len(musicBrainz.Queries))
+	}
+	if second.Title != "Album" || second.Tracks[2].Title != "Three" {
+		t.Errorf("cached disc is %q with track 3 %q", second.Title, second.Tracks[2].Title)
+	}
+	for _, field := range []string{"title", "artist", "track.1.title"} {
+		if second.Sources[field] != "cache" {
+			t.Errorf("cached %s from %q, want cache", field, second.Sources[field])
+		}
+	}
+
+	// The release it was found as is still known, e.g. for its cover.
+	if candidate := second.SelectedCandidate(); candidate == nil || candidate.Provider != "musicbrainz" || candidate.ReleaseID != "release" {
+		t.Errorf("cached candidate = %+v, want the MusicBrainz release", candidate)
+	}
+}
+
+func TestCacheStaleEntries(t *testing.T) {
+	cache := openTestCache(t, 0)
+
+	disc := testDisc(t)
+	disc.ID = "test-disc"
+	disc.SetCandidates([]*ReleaseCandidate{{Provider: "musicbrainz", Title: "Album", Artist: "Artist"}})
+	err := cache.Put(disc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, err := cache.Lookup(context.Background(), discQuery(disc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || !candidates[0].Stale || !candidates[0].Cached {
+		t.Errorf("Lookup = %+v, want one stale cached candidate", candidates)
+	}
+}
+
+func TestCacheReadsDoNotWrite(t *testing.T) {
+	cache := openTestCache(t, time.Hour)
+
+	disc := testDisc(t)
+	disc.ID = "test-disc"
+	err := cache.Put(disc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(cache.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Get(disc.ID)
+	if data, _ := os.ReadFile(cache.path); !bytes.Equal(data, saved) {
+		t.Error("a cache read rewrote the file")
+	}
+
+	// The access time is saved once the interval passed, or on Flush.
+	cache.savedAt = time.Now().Add(-cacheSaveInterval)
+	cache.Get(disc.ID)
+	if data, _ := os.ReadFile(cache.path); bytes.Equal(data, saved) {
+		t.Error("the access time was not saved after the interval")
+	}
+
+	cache.Get(disc.ID)
+	err = cache.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenMetadataCache(CacheConfig{Dir: filepath.Dir(cache.path), TTL: time.Hour, MaxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := reopened.Entry(disc.ID)
+	if !ok || !entry.UsedAt.Equal(cache.entries[disc.ID].UsedAt) {
+		t.Errorf("flushed entry = %+v, want the last access time", entry)
+	}
+}
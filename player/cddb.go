@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// CDDBClient speaks the CDDB protocol over HTTP, as served by GnuDB.
+type CDDBClient struct {
+	config CDDBConfig
+	client *http.Client
+}
+
+// CDDBMatch is a disc from a cddb query response.
+type CDDBMatch struct {
+	Category string
+	DiscID   string
+	Title    string // "Artist / Title"
+	Exact    bool
+}
+
+// XMCDEntry is a parsed xmcd record from a cddb read response.
+type XMCDEntry struct {
+	DiscID string
+	Artist string
+	Title  string
+	Year   string
+	Genre  string
+	Tracks []XMCDTrack
+}
+
+type XMCDTrack struct {
+	Artist string // only set on compilations
+	Title  string
+}
+
+func NewCDDBClient(config CDDBConfig) *CDDBClient {
+	return &CDDBClient{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// freedbID computes the CDDB disc ID of a TOC: a checksum of the track
+// start times in seconds, the disc length in seconds and the track count.
+func freedbID(TOC string) (string, error) {
+	_, _, leadout, offsets, err := parseTOC(TOC)
+	if err != nil {
+		return "", err
+	}
+
+	sum := 0
+	for _, offset := range offsets {
+		for seconds := offset / 75; seconds > 0; seconds /= 10 {
+			sum += seconds % 10
+		}
+	}
+
+	length := leadout/75 - offsets[0]/75
+
+	return fmt.Sprintf("%08x", (sum%255)<<24|length<<8|len(offsets)), nil
+}
+
+// Query finds the discs matching a TOC. Inexact matches are only returned
+// when there is no exact one.
+func (c *CDDBClient) Query(ctx context.Context, TOC string) ([]CDDBMatch, error) {
+	discID, err := freedbID(TOC)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, leadout, offsets, err := parseTOC(TOC)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"cddb", "query", discID, strconv.Itoa(len(offsets))}
+	for _, offset := range offsets {
+		args = append(args, strconv.Itoa(offset))
+	}
+	args = append(args, strconv.Itoa(leadout/75))
+
+	code, lines, err := c.command(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	switch code {
+	case 200:
+		// The match is on the status line itself.
+		match, ok := parseCDDBMatch(lines[0][4:], true)
+		if !ok {
+			return nil, fmt.Errorf("invalid cddb query response: %q", lines[0])
+		}
+		return []CDDBMatch{match}, nil
+
+	case 210, 211:
+		var matches []CDDBMatch
+		for _, line := range lines[1:] {
+			if match, ok := parseCDDBMatch(line, code == 210); ok {
+				matches = append(matches, match)
+			}
+		}
+		return matches, nil
+
+	case 202:
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("cddb query failed: %s", lines[0])
+	}
+}
+
+// Read fetches the xmcd record of a disc found by Query.
+func (c *CDDBClient) Read(ctx context.Context, match CDDBMatch) (*XMCDEntry, error) {
+	code, lines, err := c.command(ctx, "cddb", "read", match.Category, match.DiscID)
+	if err != nil {
+		return nil, err
+	}
+
+	if code != 210 {
+		return nil, fmt.Errorf("cddb read failed: %s", lines[0])
+	}
+
+	return parseXMCD(lines[1:]), nil
+}
+
+// command sends a CDDB command and returns the response code and lines,
+// the status line first and without the terminating ".".
+func (c *CDDBClient) command(ctx context.Context, args ...string) (int, []string, error) {
+	hello := strings.Join([]string{c.config.User, c.config.Host, "OSCDP", "v0.1"}, " ")
+	query := url.Values{
+		"cmd":   {strings.Join(args, " ")},
+		"hello": {hello},
+		"proto": {"6"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.config.URL+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return parseCDDBResponse(resp.Body)
+}
+
+func parseCDDBResponse(r io.Reader) (int, []string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "." {
+			break
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, fmt.Errorf("failed to read cddb response: %w", err)
+	}
+
+	if len(lines) == 0 || len(lines[0]) < 4 {
+		return 0, nil, fmt.Errorf("empty cddb response")
+	}
+
+	code, err := strconv.Atoi(lines[0][:3])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid cddb response: %q", lines[0])
+	}
+
+	return code, lines, nil
+}
+
+// parseCDDBMatch parses "category discid Artist / Title".
+func parseCDDBMatch(line string, exact bool) (CDDBMatch, bool) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return CDDBMatch{}, false
+	}
+
+	return CDDBMatch{Category: parts[0], DiscID: parts[1], Title: parts[2], Exact: exact}, true
+}
+
+// parseXMCD reads the KEY=value lines of an xmcd record. Values too long
+// for one line are continued on lines with the same key.
+func parseXMCD(lines []string) *XMCDEntry {
+	values := map[string]string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[key] += value
+	}
+
+	entry := &XMCDEntry{
+		DiscID: strings.SplitN(values["DISCID"], ",", 2)[0],
+		Year:   values["DYEAR"],
+		Genre:  values["DGENRE"],
+	}
+	entry.Artist, entry.Title = splitCDDBTitle(values["DTITLE"])
+
+	for i := 0; ; i++ {
+		title, ok := values["TTITLE"+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+
+		track := XMCDTrack{Title: title}
+		if artist, title, ok := strings.Cut(title, " / "); ok && strings.HasPrefix(entry.Artist, "Various") {
+			track.Artist, track.Title = artist, title
+		}
+		entry.Tracks = append(entry.Tracks, track)
+	}
+
+	return entry
+}
+
+// splitCDDBTitle splits "Artist / Title", a title without artist is by the
+// artist of the same name.
+func splitCDDBTitle(title string) (string, string) {
+	artist, album, ok := strings.Cut(title, " / ")
+	if !ok {
+		return strings.TrimSpace(title), strings.TrimSpace(title)
+	}
+
+	return strings.TrimSpace(artist), strings.TrimSpace(album)
+}
+
+// CDDBProvider identifies discs on a CDDB server by their freedb ID.
+type CDDBProvider struct {
+	client     *CDDBClient
+	maxMatches int
+}
+
+var _ MetadataProvider = (*CDDBProvider)(nil)
+
+func NewCDDBProvider(config CDDBConfig) *CDDBProvider {
+	return &CDDBProvider{client: NewCDDBClient(config), maxMatches: config.MaxMatches}
+}
+
+func (c *CDDBProvider) Name() string {
+	return "cddb"
+}
+
+func (c *CDDBProvider) Lookup(ctx context.Context, query *DiscQuery) ([]*ReleaseCandidate, error) {
+	if query.TOC == "" {
+		return nil, nil
+	}
+
+	matches, err := c.client.Query(ctx, query.TOC)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*ReleaseCandidate
+	for i, match := range matches {
+		if i >= c.maxMatches {
+			break
+		}
+
+		entry, err := c.client.Read(ctx, match)
+		if err != nil {
+			fmt.Printf("Failed to read cddb entry %s/%s: %v\n", match.Category, match.DiscID, err)
+			continue
+		}
+		if len(entry.Tracks) != query.Tracks {
+			continue
+		}
+
+		candidate := &ReleaseCandidate{
+			Provider:    c.Name(),
+			Confidence:  0.6,
+			Approximate: !match.Exact,
+			ReleaseID:   match.Category + "/" + match.DiscID,
+			Year:        entry.Year,
+			Title:       entry.Title,
+			Artist:      entry.Artist,
+		}
+		if !match.Exact {
+			candidate.Confidence = 0.3
+		}
+
+		for _, track := range entry.Tracks {
+			candidate.Tracks = append(candidate.Tracks, TrackMetadata{Title: track.Title, Artist: track.Artist})
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const cddbTestTOC = "1 6 95462 150 15363 32314 46592 63414 80489"
+
+// newCDDBServer answers CDDB commands from responses, keyed by the command
+// without its "cddb " prefix and disc arguments, e.g. "query" or
+// "read rock 3404f606".
+func newCDDBServer(t *testing.T, responses map[string]string) *CDDBClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hello := r.URL.Query().Get("hello"); hello != "jane example.org OSCDP v0.1" {
+			t.Errorf("hello = %q", hello)
+		}
+
+		command := strings.TrimPrefix(r.URL.Query().Get("cmd"), "cddb ")
+		if strings.HasPrefix(command, "query ") {
+			command = "query"
+		}
+
+		response, ok := responses[command]
+		if !ok {
+			response = "500 Command syntax error\r\n"
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	config := DefaultConfig().CDDB
+	config.URL = server.URL
+	config.User = "jane"
+	config.Host = "example.org"
+
+	return NewCDDBClient(config)
+}
+
+func TestCDDBQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []CDDBMatch
+	}{
+		{
+			name:     "exact match",
+			response: "200 rock 3404f606 Artist / Album\r\n",
+			want:     []CDDBMatch{{Category: "rock", DiscID: "3404f606", Title: "Artist / Album", Exact: true}},
+		},
+		{
+			name:     "exact matches",
+			response: "210 Found exact matches, list follows (until terminating `.')\r\nrock 3404f606 Artist / Album\r\nmisc 3404f606 Artist / Album (Remaster)\r\n.\r\n",
+			want: []CDDBMatch{
+				{Category: "rock", DiscID: "3404f606", Title: "Artist / Album", Exact: true},
+				{Category: "misc", DiscID: "3404f606", Title: "Artist / Album (Remaster)", Exact: true},
+			},
+		},
+		{
+			name:     "inexact matches",
+			response: "211 Found inexact matches, list follows (until terminating `.')\r\nrock 3404f607 Artist / Album\r\n.\r\n",
+			want:     []CDDBMatch{{Category: "rock", DiscID: "3404f607", Title: "Artist / Album", Exact: false}},
+		},
+		{
+			name:     "no match",
+			response: "202 No match found\r\n",
+			want:     nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newCDDBServer(t, map[string]string{"query": test.response})
+
+			matches, err := client.Query(context.Background(), cddbTestTOC)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(matches) != len(test.want) {
+				t.Fatalf("got %d matches, want %d", len(matches), len(test.want))
+			}
+			for i, match := range matches {
+				if match != test.want[i] {
+					t.Errorf("match %d = %+v, want %+v", i, match, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCDDBQueryError(t *testing.T) {
+	client := newCDDBServer(t, map[string]string{"query": "403 Database entry is corrupt\r\n"})
+
+	_, err := client.Query(context.Background(), cddbTestTOC)
+	if err == nil {
+		t.Error("error response did not fail the query")
+	}
+}
+
+const cddbTestEntry = `210 rock 3404f606 CD database entry follows (until terminating ` + "`.'" + `)
+# xmcd
+#
+DISCID=3404f606
+DTITLE=Various Artists / Compilation
+DYEAR=1999
+DGENRE=Pop
+TTITLE0=First Artist / One
+TTITLE1=Second Artist / Two
+TTITLE2=Three
+TTITLE3=Four
+TTITLE4=Five, with a title long enough to be 
+TTITLE4=continued
+TTITLE5=Six
+EXTD=
+.
+`
+
+func TestCDDBRead(t *testing.T) {
+	client := newCDDBServer(t, map[string]string{"read rock 3404f606": cddbTestEntry})
+
+	entry, err := client.Read(context.Background(), CDDBMatch{Category: "rock", DiscID: "3404f606"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Artist != "Various Artists" || entry.Title != "Compilation" || entry.Year != "1999" || entry.Genre != "Pop" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if len(entry.Tracks) != 6 {
+		t.Fatalf("got %d tracks, want 6", len(entry.Tracks))
+	}
+	if track := entry.Tracks[0]; track.Artist != "First Artist" || track.Title != "One" {
+		t.Errorf("track 1 = %+v", track)
+	}
+	if title := entry.Tracks[4].Title; title != "Five, with a title long enough to be continued" {
+		t.Errorf("continued title = %q", title)
+	}
+}
+
+func TestCDDBProviderInexactMatch(t *testing.T) {
+	// The first match has a different number of tracks, the second fits.
+	client := newCDDBServer(t, map[string]string{
+		"query":              "211 Found inexact matches, list follows (until terminating `.')\r\nrock 2a04f605 Other / Disc\r\nmisc 3404f607 Artist / Album\r\n.\r\n",
+		"read rock 2a04f605": "210 rock 2a04f605\r\nDTITLE=Other / Disc\r\nTTITLE0=One\r\nTTITLE1=Two\r\n.\r\n",
+		"read misc 3404f607": strings.ReplaceAll(cddbTestEntry, "rock 3404f606", "misc 3404f607"),
+	})
+	provider := &CDDBProvider{client: client, maxMatches: 3}
+
+	disc, err := createDisc(cddbTestTOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disc.TOC = cddbTestTOC
+
+	candidates, err := provider.Lookup(context.Background(), discQuery(disc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates, want 1", len(candidates))
+	}
+	candidate := candidates[0]
+	if candidate.ReleaseID != "misc/3404f607" || !candidate.Approximate || candidate.Title != "Compilation" {
+		t.Errorf("candidate = %v (%s), want the approximate misc/3404f607", candidate, candidate.ReleaseID)
+	}
+}
+
+func TestCDDBEmailRequired(t *testing.T) {
+	config := DefaultConfig()
+	config.Metadata.Providers = append(config.Metadata.Providers, "cddb")
+
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "email") {
+		t.Errorf("Validate without an email = %v, want an error asking for one", err)
+	}
+
+	config.CDDB.User = "jane"
+	config.CDDB.Host = "example.org"
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate with an email = %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"golang.org/x/sys/unix"
+)
+
+// Linux CD-ROM ioctls and values, see include/uapi/linux/cdrom.h
+const (
+	CDROMEJECT          = 0x5309
+	CDROMCLOSETRAY      = 0x5319
+	CDROM_SELECT_SPEED  = 0x5322
+	CDROM_MEDIA_CHANGED = 0x5325
+	CDROM_DRIVE_STATUS  = 0x5326
+	CDROM_LOCKDOOR      = 0x5329
+	CDROMREADTOCHDR     = 0x5305
+	CDROMREADTOCENTRY   = 0x5306
+	CDROMMULTISESSION   = 0x5310
+
+	CDROM_LBA        = 0x01
+	CDROM_LEADOUT    = 0xaa
+	CDROM_DATA_TRACK = 0x04
+
+	CDSL_CURRENT = math.MaxInt32
+
+	CDS_NO_INFO         = 0
+	CDS_NO_DISC         = 1
+	CDS_TRAY_OPEN       = 2
+	CDS_DRIVE_NOT_READY = 3
+	CDS_DISC_OK         = 4
+)
+
+type DriveStatus int
+
+func (s DriveStatus) String() string {
+	switch s {
+	case CDS_NO_DISC:
+		return "no disc"
+	case CDS_TRAY_OPEN:
+		return "tray open"
+	case CDS_DRIVE_NOT_READY:
+		return "not ready"
+	case CDS_DISC_OK:
+		return "disc ok"
+	default:
+		return "no info"
+	}
+}
+
+// openDrive opens the device without waiting for media, which is what the
+// cdrom driver expects for status and tray ioctls.
+func openDrive(device string) (int, error) {
+	fd, err := unix.Open(device, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open %s: %w", device, err)
+	}
+
+	return fd, nil
+}
+
+func cdromIoctl(fd int, request uintptr, arg uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), request, arg)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(r), nil
+}
+
+// readDriveStatus returns the drive status and whether the media changed
+// since the last time anyone asked.
+func readDriveStatus(device string) (DriveStatus, bool, error) {
+	fd, err := openDrive(device)
+	if err != nil {
+		return CDS_NO_INFO, false, err
+	}
+	defer unix.Close(fd)
+
+	status, err := cdromIoctl(fd, CDROM_DRIVE_STATUS, CDSL_CURRENT)
+	if err != nil {
+		return CDS_NO_INFO, false, fmt.Errorf("CDROM_DRIVE_STATUS failed: %w", err)
+	}
+
+	changed, err := cdromIoctl(fd, CDROM_MEDIA_CHANGED, CDSL_CURRENT)
+	if err != nil {
+		return DriveStatus(status), false, nil
+	}
+
+	return DriveStatus(status), changed == 1, nil
+}
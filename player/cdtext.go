@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// CD-Text pack types, see MMC-3 Annex J.
+const (
+	cdTextTitle      = 0x80
+	cdTextPerformer  = 0x81
+	cdTextSongwriter = 0x82
+	cdTextComposer   = 0x83
+	cdTextArranger   = 0x84
+	cdTextMessage    = 0x85
+	cdTextDiscID     = 0x86
+	cdTextGenre      = 0x87 // binary genre code, then its description
+	cdTextClosed     = 0x8d
+	cdTextCodes      = 0x8e // UPC/EAN on track 0, ISRCs on tracks
+	cdTextSizeInfo   = 0x8f
+
+	cdTextPackLen = 18
+)
+
+// CD-Text character codes from the size information packs.
+const (
+	cdTextISO8859_1 = 0x00
+	cdTextASCII     = 0x01
+	cdTextMSJIS     = 0x80
+	cdTextKorean    = 0x81
+	cdTextMandarin  = 0x82
+)
+
+// CDText holds the CD-Text blocks of a disc, one per language.
+type CDText struct {
+	Blocks []*CDTextBlock `json:"blocks"`
+
+	// BadPacks counts packs dropped for a CRC mismatch.
+	BadPacks int `json:"bad_packs"`
+}
+
+type CDTextBlock struct {
+	Number   int  `json:"number"`
+	Language byte `json:"language"` // EBU Tech 3258 language code, 0x09 is English
+	Charset  byte `json:"charset"`
+
+	// Fields holds the strings by pack type and track, track 0 being the
+	// whole disc.
+	Fields map[byte]map[int]string `json:"fields"`
+
+	// Genre is the disc's genre code from the table in the specification,
+	// 6 being classical, GenreText its optional description.
+	Genre     int    `json:"genre,omitempty"`
+	GenreText string `json:"genre_text,omitempty"`
+}
+
+// Supported reports whether the block's text could be decoded, double byte
+// character sets are not.
+func (b *CDTextBlock) Supported() bool {
+	return b.Charset == cdTextISO8859_1 || b.Charset == cdTextASCII
+}
+
+func (b *CDTextBlock) Field(packType byte, track int) string {
+	return b.Fields[packType][track]
+}
+
+// Block returns the first block that could be decoded, which the
+// specification reserves for the disc's main language.
+func (t *CDText) Block() *CDTextBlock {
+	for _, block := range t.Blocks {
+		if block.Supported() {
+			return block
+		}
+	}
+
+	return nil
+}
+
+// readCDTextPacks reads the raw CD-Text packs with READ TOC/PMA/ATIP format
+// 5, without the 4 byte response header.
+func readCDTextPacks(device string) ([]byte, error) {
+	fd, err := openDrive(device)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	header := make([]byte, 4)
+	_, err = scsiRead(fd, readTOCCommand(5, len(header)), header, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CD-Text: %w", err)
+	}
+
+	// The data length excludes the length field itself.
+	length := int(binary.BigEndian.Uint16(header)) + 2
+	if length <= len(header) {
+		return nil, nil
+	}
+
+	buf := make([]byte, length)
+	n, err := scsiRead(fd, readTOCCommand(5, len(buf)), buf, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CD-Text: %w", err)
+	}
+	if n < len(header) {
+		return nil, fmt.Errorf("CD-Text response is %d bytes, shorter than its header", n)
+	}
+
+	return buf[4:n], nil
+}
+
+func readTOCCommand(format byte, allocation int) []byte {
+	cdb := make([]byte, 10)
+	cdb[0] = 0x43 // READ TOC/PMA/ATIP
+	cdb[2] = format & 0x0f
+	binary.BigEndian.PutUint16(cdb[7:], uint16(allocation))
+
+	return cdb
+}
+
+// cdTextCRC is the CRC-16/CCITT of a pack, stored inverted in its last two
+// bytes.
+func cdTextCRC(pack []byte) uint16 {
+	var crc uint16
+	for _, b := range pack[:16] {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return ^crc
+}
+
+// parseCDText splits the packs into blocks and assembles their strings.
+// Text runs over packs as NUL terminated strings, one per track starting at
+// the track in the first pack, a lone tab repeats the previous track's text.
+func parseCDText(data []byte) (*CDText, error) {
+	if len(data)%cdTextPackLen != 0 {
+		return nil, fmt.Errorf("CD-Text is %d bytes, not a multiple of %d", len(data), cdTextPackLen)
+	}
+
+	cdText := &CDText{}
+	blocks := map[int]*CDTextBlock{}
+	text := map[int]map[byte][]byte{}
+	firstTrack := map[int]map[byte]int{}
+
+	for offset := 0; offset < len(data); offset += cdTextPackLen {
+		pack := data[offset : offset+cdTextPackLen]
+		if binary.BigEndian.Uint16(pack[16:]) != cdTextCRC(pack) {
+			cdText.BadPacks++
+			continue
+		}
+
+		packType := pack[0]
+		track := int(pack[1] & 0x7f)
+		number := int(pack[3]>>4) & 0x07
+
+		block, ok := blocks[number]
+		if !ok {
+			block = &CDTextBlock{Number: number, Fields: map[byte]map[int]string{}}
+			blocks[number] = block
+			text[number] = map[byte][]byte{}
+			firstTrack[number] = map[byte]int{}
+		}
+
+		if packType == cdTextSizeInfo {
+			// The 3 size information packs are one 36 byte structure, its
+			// first byte is the character code and bytes 28-35 the
+			// languages of blocks 0-7.
+			switch track {
+			case 0:
+				block.Charset = pack[4]
+			case 2:
+				block.Language = pack[4+number+4]
+			}
+			continue
+		}
+
+		// The TOC packs 0x88 and 0x89 are binary, the genre pack is
+		// collected like text and decoded on its own below.
+		if !cdTextHasText(packType) && packType != cdTextGenre {
+			continue
+		}
+
+		if _, ok := text[number][packType]; !ok {
+			firstTrack[number][packType] = track
+		}
+		text[number][packType] = append(text[number][packType], pack[4:16]...)
+	}
+
+	for number := 0; number < 8; number++ {
+		block, ok := blocks[number]
+		if !ok {
+			continue
+		}
+
+		if raw := text[number][cdTextGenre]; len(raw) >= 2 {
+			block.Genre = int(binary.BigEndian.Uint16(raw))
+			if block.Supported() {
+				description, _, _ := strings.Cut(string(raw[2:]), "\x00")
+				block.GenreText = decodeCDText(description, block.Charset)
+			}
+			delete(text[number], cdTextGenre)
+		}
+
+		if block.Supported() {
+			for packType, raw := range text[number] {
+				block.Fields[packType] = splitCDTextStrings(raw, firstTrack[number][packType], block.Charset)
+			}
+		}
+		cdText.Blocks = append(cdText.Blocks, block)
+	}
+
+	return cdText, nil
+}
+
+// cdTextHasText reports whether the pack type holds a string per track.
+func cdTextHasText(packType byte) bool {
+	return packType >= cdTextTitle && packType <= cdTextDiscID || packType == cdTextClosed || packType == cdTextCodes
+}
+
+func splitCDTextStrings(raw []byte, track int, charset byte) map[int]string {
+	values := map[int]string{}
+
+	previous := ""
+	for _, field := range strings.Split(string(raw), "\x00") {
+		value := field
+		if value == "\t" {
+			value = previous
+		}
+		if value != "" {
+			values[track] = decodeCDText(value, charset)
+		}
+
+		previous = value
+		track++
+	}
+
+	return values
+}
+
+func decodeCDText(value string, charset byte) string {
+	if charset != cdTextISO8859_1 {
+		return strings.TrimSpace(value)
+	}
+
+	runes := make([]rune, len(value))
+	for i := 0; i < len(value); i++ {
+		runes[i] = rune(value[i])
+	}
+
+	return strings.TrimSpace(string(runes))
+}
+
+// CDTextProvider identifies the disc in the drive by its own CD-Text. It
+// is meant to go last, filling in what nothing else knows.
+type CDTextProvider struct {
+	device string
+}
+
+var _ MetadataProvider = (*CDTextProvider)(nil)
+
+func NewCDTextProvider(device string) *CDTextProvider {
+	return &CDTextProvider{device: device}
+}
+
+func (c *CDTextProvider) Name() string {
+	return "cdtext"
+}
+
+func (c *CDTextProvider) Lookup(ctx context.Context, query *DiscQuery) ([]*ReleaseCandidate, error) {
+	data, err := readCDTextPacks(c.device)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	cdText, err := parseCDText(data)
+	if err != nil {
+		return nil, err
+	}
+
+	block := cdText.Block()
+	if block == nil {
+		return nil, nil
+	}
+
+	candidate := &ReleaseCandidate{
+		Provider:   c.Name(),
+		Confidence: 0.5,
+		Local:      true,
+		Title:      block.Field(cdTextTitle, 0),
+		Artist:     block.Field(cdTextPerformer, 0),
+	}
+	for track := 1; track <= query.Tracks; track++ {
+		candidate.Tracks = append(candidate.Tracks, TrackMetadata{
+			Title:  block.Field(cdTextTitle, track),
+			Artist: block.Field(cdTextPerformer, track),
+		})
+	}
+
+	return []*ReleaseCandidate{candidate}, nil
+}
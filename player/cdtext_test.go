@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// CD-Text as READ TOC/PMA/ATIP format 5 returns it after the header, one
+// 18 byte pack per line: type, track, sequence number, block and character
+// position, 12 bytes of text and the CRC.
+const cdTextSingleBlock = `
+	80000000416c62756d004f6e65005477f670
+	800201026f0054687265650000000000989e
+	8100020042616e6400090009000900004f2c
+	8f0003000001030002010000000000005d76
+	8f0104000000000000000003050000008f5a
+	8f0205000000000009000000000000001c80
+`
+
+const cdTextTwoBlocks = `
+	80000000416c62756d004f6e65005477f670
+	800201026f0054687265650000000000989e
+	8100020042616e6400090009000900004f2c
+	8f0003000001030002010000000000005d76
+	8f0104000000000000000003050c0000fa3b
+	8f0205000000000009080000000000008f2d
+	80000610416c62756d20284465757473298c
+	8000071c6368290045696e73005a77654703
+	8002081369004472656920dc62657200ef88
+	8100091042616e6400090009000900003f50
+	8f000a100001030003010000000000009c1b
+	8f010b100000000000000003050c000077e2
+	8f020c100000000009080000000000000993
+`
+
+// cdTextGenreBlock is cdTextSingleBlock with a genre pack, classical with
+// the description "Baroque", and a TOC pack.
+const cdTextGenreBlock = `
+	80000000416c62756d004f6e65005477f670
+	800201026f0054687265650000000000989e
+	8100020042616e6400090009000900004f2c
+	8700030000064261726f71756500000081f3
+	880004000103000d1b00000000000000d509
+	8f0005000001030002010000000000014630
+	8f0106000100000000000003070000009785
+	8f020700000000000900000000000000ea42
+`
+
+func decodeCDTextDump(t *testing.T, dump string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(strings.Join(strings.Fields(dump), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestParseCDText(t *testing.T) {
+	cdText, err := parseCDText(decodeCDTextDump(t, cdTextSingleBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cdText.Blocks) != 1 || cdText.BadPacks != 0 {
+		t.Fatalf("got %d blocks and %d bad packs, want 1 and 0", len(cdText.Blocks), cdText.BadPacks)
+	}
+
+	block := cdText.Block()
+	if block.Language != 0x09 || block.Charset != cdTextISO8859_1 {
+		t.Errorf("block language %#x charset %#x, want English ISO 8859-1", block.Language, block.Charset)
+	}
+
+	tests := []struct {
+		packType byte
+		track    int
+		want     string
+	}{
+		{cdTextTitle, 0, "Album"},
+		{cdTextTitle, 1, "One"},
+		{cdTextTitle, 2, "Two"}, // starts in the first pack, ends in the second
+		{cdTextTitle, 3, "Three"},
+		{cdTextPerformer, 0, "Band"},
+		{cdTextPerformer, 3, "Band"}, // a tab repeats the previous track
+		{cdTextSongwriter, 1, ""},
+	}
+	for _, test := range tests {
+		if got := block.Field(test.packType, test.track); got != test.want {
+			t.Errorf("pack %#x track %d = %q, want %q", test.packType, test.track, got, test.want)
+		}
+	}
+}
+
+func TestParseCDTextBlocks(t *testing.T) {
+	cdText, err := parseCDText(decodeCDTextDump(t, cdTextTwoBlocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cdText.Blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(cdText.Blocks))
+	}
+
+	english, german := cdText.Blocks[0], cdText.Blocks[1]
+	if cdText.Block() != english || english.Language != 0x09 || german.Language != 0x08 {
+		t.Errorf("blocks in languages %#x and %#x, first supported is %d", english.Language, german.Language, cdText.Block().Number)
+	}
+
+	if title := english.Field(cdTextTitle, 0); title != "Album" {
+		t.Errorf("English title = %q", title)
+	}
+	if title := german.Field(cdTextTitle, 0); title != "Album (Deutsch)" {
+		t.Errorf("German title = %q", title)
+	}
+	if title := german.Field(cdTextTitle, 3); title != "Drei Über" {
+		t.Errorf("German track 3 = %q", title)
+	}
+}
+
+func TestParseCDTextGenre(t *testing.T) {
+	cdText, err := parseCDText(decodeCDTextDump(t, cdTextGenreBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := cdText.Block()
+	if block.Genre != 6 || block.GenreText != "Baroque" {
+		t.Errorf("genre %d %q, want 6 \"Baroque\"", block.Genre, block.GenreText)
+	}
+
+	// The binary packs are no text fields.
+	for _, packType := range []byte{cdTextGenre, 0x88} {
+		if fields, ok := block.Fields[packType]; ok {
+			t.Errorf("pack %#x decoded as text %q", packType, fields)
+		}
+	}
+	if title := block.Field(cdTextTitle, 3); title != "Three" {
+		t.Errorf("title next to binary packs = %q, want Three", title)
+	}
+}
+
+func TestParseCDTextBadCRC(t *testing.T) {
+	data := decodeCDTextDump(t, cdTextSingleBlock)
+	// Damage the performer pack.
+	data[2*cdTextPackLen+5] ^= 0x01
+
+	cdText, err := parseCDText(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cdText.BadPacks != 1 {
+		t.Errorf("got %d bad packs, want 1", cdText.BadPacks)
+	}
+
+	block := cdText.Block()
+	if performer := block.Field(cdTextPerformer, 0); performer != "" {
+		t.Errorf("performer from a bad pack = %q", performer)
+	}
+	if title := block.Field(cdTextTitle, 3); title != "Three" {
+		t.Errorf("title next to a bad pack = %q, want Three", title)
+	}
+}
+
+func TestParseCDTextTruncated(t *testing.T) {
+	data := decodeCDTextDump(t, cdTextSingleBlock)
+
+	_, err := parseCDText(data[:len(data)-1])
+	if err == nil {
+		t.Error("truncated pack was parsed")
+	}
+}
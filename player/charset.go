@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Display charsets of the controller. Its fonts only have ASCII glyphs, a
+// firmware with wider fonts can take UTF-8.
+const (
+	charsetASCII = "ascii"
+	charsetUTF8  = "utf-8"
+)
+
+// isLatin reports whether all letters of s are in Latin script.
+func isLatin(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+var asciiFolds = map[rune]string{}
+
+func init() {
+	for chars, ascii := range map[string]string{
+		"ÀÁÂÃÄÅĀĂĄ": "A", "àáâãäåāăą": "a", "ÇĆĈĊČ": "C", "çćĉċč": "c",
+		"ĎĐ": "D", "ďđ": "d", "ÈÉÊËĒĔĖĘĚ": "E", "èéêëēĕėęě": "e",
+		"ĜĞĠĢ": "G", "ĝğġģ": "g", "ĤĦ": "H", "ĥħ": "h",
+		"ÌÍÎÏĨĪĬĮİ": "I", "ìíîïĩīĭįı": "i", "Ĵ": "J", "ĵ": "j", "Ķ": "K", "ķ": "k",
+		"ĹĻĽĿŁ": "L", "ĺļľŀł": "l", "ÑŃŅŇ": "N", "ñńņňŉ": "n",
+		"ÒÓÔÕÖØŌŎŐ": "O", "òóôõöøōŏő": "o", "ŔŖŘ": "R", "ŕŗř": "r",
+		"ŚŜŞŠ": "S", "śŝşšſ": "s", "ŢŤŦ": "T", "ţťŧ": "t",
+		"ÙÚÛÜŨŪŬŮŰŲ": "U", "ùúûüũūŭůűų": "u", "Ŵ": "W", "ŵ": "w",
+		"ÝŸŶ": "Y", "ýÿŷ": "y", "ŹŻŽ": "Z", "źżž": "z",
+		"Æ": "AE", "æ": "ae", "Œ": "OE", "œ": "oe", "ß": "ss", "Þ": "Th", "þ": "th",
+		"Ð": "D", "ð": "d", "Ĳ": "IJ", "ĳ": "ij",
+
+		"‘’‚′": "'", "“”„″«»「」『』": `"`, "‐‑‒–—―": "-", "…": "...",
+		"\u00a0\u3000・": " ", "、": ",", "。": ".", "〜～": "~", "×": "x",
+	} {
+		for _, r := range chars {
+			asciiFolds[r] = ascii
+		}
+	}
+
+	// Russian and Ukrainian Cyrillic, uppercase letters are 0x20 below.
+	cyrillic := strings.Split("a b v g d e zh z i y k l m n o p r s t u f kh ts ch sh shch  y  e yu ya", " ")
+	for i, latin := range cyrillic {
+		asciiFolds[rune(0x430+i)] = latin
+		asciiFolds[rune(0x410+i)] = capitalize(latin)
+	}
+	for r, latin := range map[rune]string{'ё': "yo", 'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g"} {
+		asciiFolds[r] = latin
+		asciiFolds[unicode.ToUpper(r)] = capitalize(latin)
+	}
+
+	// Greek, the final sigma has no uppercase of its own.
+	greek := strings.Split("a v g d e z i th i k l m n x o p r s s t y f ch ps o", " ")
+	for i, latin := range greek {
+		asciiFolds[rune(0x3b1+i)] = latin
+		if upper := unicode.ToUpper(rune(0x3b1 + i)); upper != rune(0x3b1+i) {
+			asciiFolds[upper] = capitalize(latin)
+		}
+	}
+	for chars, latin := range map[string]string{"άΆ": "a", "έΈ": "e", "ήΉ": "i", "ίϊΐΊ": "i", "όΌ": "o", "ύϋΰΎ": "y", "ώΏ": "o"} {
+		for _, r := range chars {
+			if unicode.IsUpper(r) {
+				asciiFolds[r] = capitalize(latin)
+			} else {
+				asciiFolds[r] = latin
+			}
+		}
+	}
+
+	// Hiragana from U+3041, katakana are 0x60 above. The small tsu
+	// doubles the next consonant and is handled by foldASCII.
+	kana := strings.Split("a a i i u u e e o o ka ga ki gi ku gu ke ge ko go "+
+		"sa za shi ji su zu se ze so zo ta da chi ji - tsu zu te de to do "+
+		"na ni nu ne no ha ba pa hi bi pi fu bu pu he be pe ho bo po "+
+		"ma mi mu me mo ya ya yu yu yo yo ra ri ru re ro wa wa i e o n vu ka ke", " ")
+	for i, latin := range kana {
+		if latin == "-" {
+			continue
+		}
+		asciiFolds[rune(0x3041+i)] = latin
+		asciiFolds[rune(0x30a1+i)] = latin
+	}
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func isSmallKana(r rune, small string) bool {
+	if r >= 0x30a1 && r <= 0x30f6 {
+		r -= 0x60
+	}
+
+	return strings.ContainsRune(small, r)
+}
+
+// foldASCII transliterates s to ASCII: accents are dropped, Cyrillic,
+// Greek and kana are romanized, anything else becomes "?".
+func foldASCII(s string) string {
+	runes := []rune(s)
+
+	var out strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r < 0x80:
+			out.WriteRune(r)
+			continue
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r >= 0xff01 && r <= 0xff5e:
+			// Fullwidth forms of ASCII.
+			out.WriteRune(r - 0xfee0)
+			continue
+		}
+
+		latin, ok := asciiFolds[r]
+		if !ok {
+			switch {
+			case isSmallKana(r, "っ"):
+				// Sokuon, "tch" before ch.
+				if i+1 >= len(runes) {
+					break
+				}
+				if next := asciiFolds[runes[i+1]]; next != "" {
+					if strings.HasPrefix(next, "ch") {
+						out.WriteByte('t')
+					} else {
+						out.WriteByte(next[0])
+					}
+				}
+			case r == 'ー':
+				// The long vowel mark repeats the previous vowel.
+				if text := out.String(); text != "" && strings.ContainsRune("aiueo", rune(text[len(text)-1])) {
+					out.WriteByte(text[len(text)-1])
+				}
+			default:
+				out.WriteByte('?')
+			}
+			continue
+		}
+
+		// Small ya, yu and yo make kya, sha and ja from ki, shi and ji,
+		// small vowels make fa or ti from fu and te.
+		if i+1 < len(runes) && len(latin) > 1 {
+			if isSmallKana(runes[i+1], "ゃゅょ") && strings.HasSuffix(latin, "i") {
+				latin = strings.TrimSuffix(latin, "i")
+				if !strings.HasSuffix(latin, "h") && latin != "j" {
+					latin += "y"
+				}
+				latin += asciiFolds[runes[i+1]][1:]
+				i++
+			} else if isSmallKana(runes[i+1], "ぁぃぅぇぉ") {
+				latin = latin[:len(latin)-1] + asciiFolds[runes[i+1]]
+				i++
+			}
+		}
+
+		out.WriteString(latin)
+	}
+
+	return out.String()
+}
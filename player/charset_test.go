@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestFoldASCII(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Plain ASCII", "Plain ASCII"},
+		{"Café Müller", "Cafe Muller"},
+		{"Dvořák: Rusalka", "Dvorak: Rusalka"},
+		{"Cafe\u0301", "Cafe"}, // combining accent
+		{"Ærøskøbing", "AEroskobing"},
+		{"Œuvres complètes", "OEuvres completes"},
+		{"Straße", "Strasse"},
+		{"“Quoted” – don’t…", `"Quoted" - don't...`},
+		{"Кино", "Kino"},
+		{"Щедрик", "Shchedrik"},
+		{"Αθήνα", "Athina"},
+		{"ＡＢＣ１２３", "ABC123"},
+		{"ちゃ", "cha"},
+		{"トッキュウ", "tokkyuu"},
+		{"ラーメン", "raamen"},
+		{"マッチ", "matchi"},
+		{"東京", "??"},
+		{"Song 🎵", "Song ?"},
+	}
+
+	for _, test := range tests {
+		if got := foldASCII(test.in); got != test.want {
+			t.Errorf("foldASCII(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestIsLatin(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"Ryuichi Sakamoto", true},
+		{"Sakamoto Ryūichi", true},
+		{"AC/DC 1979!", true},
+		{"坂本龍一", false},
+		{"Кино", false},
+		{"Kino (Кино)", false},
+	}
+
+	for _, test := range tests {
+		if got := isLatin(test.in); got != test.want {
+			t.Errorf("isLatin(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const commandUsage = `usage: player [flags] [command]
+
+commands:
+  help                       print this help
+  print-config               print the effective configuration
+  cache list                 list cached discs
+  cache show <discid>        print a cached disc as JSON
+  cache select <discid> <n>  show a cached disc as its n-th release candidate
+  cache purge [discid...]    remove some or all cached discs
+  cache seed <discid...>     look discs up on MusicBrainz and cache them
+  cache import <file.json>   cache a disc from a JSON file
+  meta edit <discid>         edit the metadata override of a disc
+  cdtext [file]              print the CD-Text of the disc, or of packs saved to file
+  cdtext save <file>         save the raw CD-Text packs of the disc to file`
+
+// runCommand runs a command given on the command line instead of the player.
+func runCommand(config *Config, args []string) error {
+	switch args[0] {
+	case "help":
+		fmt.Println(commandUsage)
+		return nil
+	case "print-config":
+		return config.Print(os.Stdout)
+	case "cache":
+		return runCacheCommand(config, args[1:])
+	case "meta":
+		return runMetaCommand(config, args[1:])
+	case "cdtext":
+		return runCDTextCommand(config, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandUsage)
+	}
+}
+
+func runCacheCommand(config *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing cache command\n%s", commandUsage)
+	}
+
+	cache, err := OpenMetadataCache(config.Cache)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		for _, entry := range cache.List() {
+			fmt.Printf("%s  %s  %s - %s (%d tracks)\n", entry.Disc.ID, entry.FetchedAt.Format(time.DateOnly), entry.Disc.Artist, entry.Disc.Title, len(entry.Disc.Tracks))
+			for i, candidate := range entry.Disc.Candidates {
+				marker := " "
+				if i == entry.Disc.Candidate {
+					marker = "*"
+				}
+				fmt.Printf("    %s %d. %v\n", marker, i+1, candidate)
+			}
+		}
+		return nil
+
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: cache show <discid>")
+		}
+
+		entry, ok := cache.Entry(args[1])
+		if !ok {
+			return fmt.Errorf("disc %s is not cached", args[1])
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entry)
+
+	case "select":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: cache select <discid> <n>")
+		}
+
+		entry, ok := cache.Entry(args[1])
+		if !ok {
+			return fmt.Errorf("disc %s is not cached", args[1])
+		}
+
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid candidate %q: %w", args[2], err)
+		}
+
+		disc := entry.Disc.Copy()
+		err = disc.SelectCandidate(n - 1)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s  %v\n", disc.ID, disc.SelectedCandidate())
+
+		return cache.Put(disc)
+
+	case "purge":
+		if len(args) == 1 {
+			return cache.Purge()
+		}
+
+		for _, discID := range args[1:] {
+			err = cache.Delete(discID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "seed":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: cache seed <discid...>")
+		}
+
+		for _, discID := range args[1:] {
+			disc, err := seedDisc(config.MusicBrainz, discID)
+			if err != nil {
+				return fmt.Errorf("failed to seed %s: %w", discID, err)
+			}
+
+			err = cache.Put(disc)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s  %s - %s\n", disc.ID, disc.Artist, disc.Title)
+		}
+		return nil
+
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: cache import <file.json>")
+		}
+
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+
+		var disc Disc
+		err = json.Unmarshal(data, &disc)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", args[1], err)
+		}
+		if disc.ID == "" || len(disc.Tracks) == 0 {
+			return fmt.Errorf("%s needs a disc id and tracks", args[1])
+		}
+
+		return cache.Put(&disc)
+
+	default:
+		return fmt.Errorf("unknown cache command %q\n%s", args[0], commandUsage)
+	}
+}
+
+// runMetaCommand opens the override of a disc in $VISUAL or $EDITOR, or
+// just writes it without an editor. New overrides are prefilled with what
+// the cache knows about the disc or the disc in the drive.
+func runMetaCommand(config *Config, args []string) error {
+	if len(args) != 2 || args[0] != "edit" {
+		return fmt.Errorf("usage: meta edit <discid>")
+	}
+	discID := args[1]
+
+	dir, err := overridesDir(config.Metadata)
+	if err != nil {
+		return err
+	}
+	overrides := NewOverridesProvider(dir)
+	path := overrides.Path(discID)
+
+	// An existing override is only checked against the cached disc, a new
+	// one needs the disc from the drive and the providers for its template.
+	var disc *Disc
+	_, err = os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		disc = knownDisc(config, discID)
+		if disc == nil {
+			return fmt.Errorf("disc %s is neither cached nor in the drive", discID)
+		}
+
+		err = overrides.Template(disc)
+		if err != nil {
+			return fmt.Errorf("failed to write override: %w", err)
+		}
+	} else {
+		disc, _ = cachedDisc(config, discID)
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		fmt.Println("No $EDITOR set, edit", path)
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("editor failed: %w", err)
+	}
+
+	override, err := overrides.Load(discID)
+	if err != nil {
+		return fmt.Errorf("invalid override %s: %w", path, err)
+	}
+
+	if disc != nil {
+		err = override.Validate(len(disc.Tracks))
+		if err != nil {
+			return fmt.Errorf("invalid override %s: %w", path, err)
+		}
+	}
+
+	fmt.Println("Saved", path)
+	return nil
+}
+
+// cachedDisc returns the disc from the metadata cache, along with the cache
+// when it is enabled.
+func cachedDisc(config *Config, discID string) (*Disc, *MetadataCache) {
+	if !config.Cache.Enabled {
+		return nil, nil
+	}
+
+	cache, err := OpenMetadataCache(config.Cache)
+	if err != nil {
+		fmt.Printf("Failed to open metadata cache: %v\n", err)
+		return nil, nil
+	}
+
+	if entry, ok := cache.Entry(discID); ok {
+		return entry.Disc.Copy(), cache
+	}
+	return nil, cache
+}
+
+// knownDisc returns the disc from the cache or, when it is the one in the
+// drive, as the metadata providers identify it.
+func knownDisc(config *Config, discID string) *Disc {
+	disc, cache := cachedDisc(config, discID)
+	if disc != nil {
+		return disc
+	}
+
+	disc, err := readDisc(config.Drive.Device)
+	if err != nil || disc.ID != discID {
+		return nil
+	}
+
+	NewMetadataChain(config, cache).Identify(context.Background(), disc)
+
+	return disc
+}
+
+func runCDTextCommand(config *Config, args []string) error {
+	var data []byte
+	var err error
+
+	switch {
+	case len(args) == 2 && args[0] == "save":
+		data, err = readCDTextPacks(config.Drive.Device)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(args[1], data, 0644)
+
+	case len(args) == 1:
+		data, err = os.ReadFile(args[0])
+	case len(args) == 0:
+		data, err = readCDTextPacks(config.Drive.Device)
+	default:
+		return fmt.Errorf("usage: cdtext [file] | cdtext save <file>")
+	}
+	if err != nil {
+		return err
+	}
+
+	cdText, err := parseCDText(data)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(cdText)
+}
+
+// seedDisc builds a disc without the disc in the drive, the track layout
+// comes from the TOC MusicBrainz stores with the disc ID.
+func seedDisc(config MusicBrainzConfig, discID string) (*Disc, error) {
+	discInfo, err := NewMusicBrainzClient(config).DiscID(context.Background(), discID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, release := range discInfo.Releases {
+		for _, medium := range release.Media {
+			for _, d := range medium.Discs {
+				if d.ID != discID {
+					continue
+				}
+
+				toc := []string{"1", strconv.Itoa(len(d.Offsets)), strconv.Itoa(d.Sectors)}
+				for _, offset := range d.Offsets {
+					toc = append(toc, strconv.Itoa(offset))
+				}
+
+				disc, err := createDisc(strings.Join(toc, " "))
+				if err != nil {
+					return nil, err
+				}
+				disc.ID = discID
+				disc.TOC = strings.Join(toc, " ")
+
+				disc.SetCandidates(sortCandidates(releaseCandidates("musicbrainz", config, discQuery(disc), discInfo)))
+
+				return disc, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("disc not found on MusicBrainz")
+}
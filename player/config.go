@@ -0,0 +1,431 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const defaultConfigPath = "/etc/oscdp/config.toml"
+
+type Config struct {
+	Drive       DriveConfig       `toml:"drive"`
+	Controller  ControllerConfig  `toml:"controller"`
+	MPV         MPVConfig         `toml:"mpv"`
+	Metadata    MetadataConfig    `toml:"metadata"`
+	MusicBrainz MusicBrainzConfig `toml:"musicbrainz"`
+	CDDB        CDDBConfig        `toml:"cddb"`
+	Cache       CacheConfig       `toml:"cache"`
+	Art         ArtConfig         `toml:"art"`
+}
+
+type DriveConfig struct {
+	Device           string        `toml:"device"`
+	Uevents          bool          `toml:"uevents"`
+	PollInterval     time.Duration `toml:"poll_interval"`
+	Speed            int           `toml:"speed"` // read speed multiple, 0 keeps the drive's default
+	LockWhilePlaying bool          `toml:"lock_while_playing"`
+}
+
+type ControllerConfig struct {
+	Enabled    bool          `toml:"enabled"`
+	Port       string        `toml:"port"`
+	BaudRate   int           `toml:"baud_rate"`
+	WriteDelay time.Duration `toml:"write_delay"`
+	Charset    string        `toml:"charset"` // "ascii" or "utf-8", what the display fonts cover
+}
+
+type MPVConfig struct {
+	Binary         string        `toml:"binary"`
+	RuntimeDir     string        `toml:"runtime_dir"`
+	StartTimeout   time.Duration `toml:"start_timeout"`
+	CommandTimeout time.Duration `toml:"command_timeout"`
+	MinBackoff     time.Duration `toml:"min_backoff"`
+	MaxBackoff     time.Duration `toml:"max_backoff"`
+}
+
+type MetadataConfig struct {
+	Providers    []string      `toml:"providers"` // asked in this order
+	Timeout      time.Duration `toml:"timeout"`   // for the whole lookup
+	OverridesDir string        `toml:"overrides_dir"`
+}
+
+type MusicBrainzConfig struct {
+	Enabled   bool          `toml:"enabled"`
+	URL       string        `toml:"url"`
+	UserAgent string        `toml:"user_agent"`
+	Contact   string        `toml:"contact"` // added to the user agent, e.g. an email address
+	Proxy     string        `toml:"proxy"`   // HTTP(S) proxy URL, empty uses $HTTPS_PROXY
+	Timeout   time.Duration `toml:"timeout"` // per request
+	RateLimit time.Duration `toml:"rate_limit"`
+	Retries   int           `toml:"retries"` // for 429 and 503 responses
+
+	// Works looks up the composer, work and performers of every track of
+	// the best release, for classical discs.
+	Works bool `toml:"works"`
+
+	// Releases matching earlier entries are preferred, e.g. countries = ["GB", "XE"].
+	Countries []string `toml:"countries"`
+	Formats   []string `toml:"formats"`
+	Statuses  []string `toml:"statuses"`
+}
+
+// CDDBConfig is used when "cddb" is one of the metadata providers.
+type CDDBConfig struct {
+	URL        string        `toml:"url"`
+	User       string        `toml:"user"` // GnuDB wants an email address as user@host
+	Host       string        `toml:"host"`
+	Timeout    time.Duration `toml:"timeout"`
+	MaxMatches int           `toml:"max_matches"` // entries read for inexact matches
+}
+
+type CacheConfig struct {
+	Enabled    bool          `toml:"enabled"`
+	Dir        string        `toml:"dir"` // defaults to $XDG_STATE_HOME/oscdp
+	TTL        time.Duration `toml:"ttl"`
+	MaxEntries int           `toml:"max_entries"`
+}
+
+// ArtConfig is about the cover shown on the controller, read from
+// <dir>/<disc ID>/folder.jpg or fetched from the Cover Art Archive.
+type ArtConfig struct {
+	Enabled  bool          `toml:"enabled"`
+	Dir      string        `toml:"dir"` // local covers, optional
+	URL      string        `toml:"url"`
+	CacheDir string        `toml:"cache_dir"` // defaults to $XDG_STATE_HOME/oscdp/art
+	Timeout  time.Duration `toml:"timeout"`
+	Size     int           `toml:"size"` // of the square thumbnail in pixels
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Drive: DriveConfig{
+			Device:           "/dev/sr0",
+			Uevents:          true,
+			PollInterval:     500 * time.Millisecond,
+			LockWhilePlaying: true,
+		},
+		Controller: ControllerConfig{
+			Enabled:    true,
+			Port:       "/dev/ttyACM0",
+			BaudRate:   115200,
+			WriteDelay: 10 * time.Millisecond,
+			Charset:    charsetASCII,
+		},
+		MPV: MPVConfig{
+			Binary:         "mpv",
+			StartTimeout:   30 * time.Second,
+			CommandTimeout: 2 * time.Second,
+			MinBackoff:     1 * time.Second,
+			MaxBackoff:     30 * time.Second,
+		},
+		Metadata: MetadataConfig{
+			Providers: []string{"overrides", "cache", "musicbrainz", "cdtext"},
+			Timeout:   30 * time.Second,
+		},
+		MusicBrainz: MusicBrainzConfig{
+			Enabled:   true,
+			URL:       "https://musicbrainz.org/ws/2/",
+			UserAgent: "OSCDP/v0.1",
+			Contact:   "danilo.fragoso@gmail.com",
+			Timeout:   10 * time.Second,
+			RateLimit: 1 * time.Second,
+			Retries:   4,
+			Formats:   []string{"CD", "Enhanced CD", "HDCD"},
+			Statuses:  []string{"Official"},
+		},
+		CDDB: CDDBConfig{
+			URL:        "https://gnudb.gnudb.org/~cddb/cddb.cgi",
+			Timeout:    10 * time.Second,
+			MaxMatches: 3,
+		},
+		Cache: CacheConfig{
+			Enabled:    true,
+			TTL:        30 * 24 * time.Hour,
+			MaxEntries: 1000,
+		},
+		Art: ArtConfig{
+			Enabled: true,
+			URL:     "https://coverartarchive.org",
+			Timeout: 20 * time.Second,
+			Size:    72,
+		},
+	}
+}
+
+// LoadConfig builds the configuration from, in increasing priority, the
+// defaults, the config file, OSCDP_<SECTION>_<KEY> environment variables
+// and -<section>.<key> flags. Arguments after the flags are returned as a
+// command to run instead of the player, --print-config is the same as the
+// "print-config" command.
+func LoadConfig(args []string) (config *Config, command []string, err error) {
+	config = DefaultConfig()
+
+	flags := flag.NewFlagSet("oscdp", flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to the config file (default "+defaultConfigPath+")")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration and exit")
+
+	overrides := map[string]*configFlag{}
+	walkConfig(config, func(name string, _ reflect.Value) {
+		overrides[name] = &configFlag{}
+		flags.Var(overrides[name], name, "override "+name)
+	})
+
+	err = flags.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("OSCDP_CONFIG")
+	}
+
+	if path != "" {
+		_, err = toml.DecodeFile(path, config)
+	} else {
+		_, err = toml.DecodeFile(defaultConfigPath, config)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	walkConfig(config, func(name string, value reflect.Value) {
+		if err != nil {
+			return
+		}
+
+		env := "OSCDP_" + strings.ToUpper(strings.ReplaceAll(name, ".", "_"))
+		if raw, ok := os.LookupEnv(env); ok {
+			err = setConfigValue(value, raw)
+			if err != nil {
+				err = fmt.Errorf("invalid %s: %w", env, err)
+				return
+			}
+		}
+
+		if override := overrides[name]; override.set {
+			err = setConfigValue(value, override.raw)
+			if err != nil {
+				err = fmt.Errorf("invalid -%s: %w", name, err)
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	command = flags.Args()
+	if *printConfig {
+		command = []string{"print-config"}
+	}
+
+	return config, command, nil
+}
+
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Drive.Device == "" {
+		problems = append(problems, "drive.device must be set")
+	}
+	if c.Drive.PollInterval <= 0 {
+		problems = append(problems, "drive.poll_interval must be positive")
+	}
+	if c.Drive.Speed < 0 {
+		problems = append(problems, "drive.speed must not be negative")
+	}
+
+	if c.Controller.Enabled {
+		if c.Controller.Port == "" {
+			problems = append(problems, "controller.port must be set")
+		}
+		if c.Controller.BaudRate <= 0 {
+			problems = append(problems, "controller.baud_rate must be positive")
+		}
+	}
+	if c.Controller.WriteDelay < 0 {
+		problems = append(problems, "controller.write_delay must not be negative")
+	}
+	if c.Controller.Charset != charsetASCII && c.Controller.Charset != charsetUTF8 {
+		problems = append(problems, `controller.charset must be "ascii" or "utf-8"`)
+	}
+
+	if c.MPV.Binary == "" {
+		problems = append(problems, "mpv.binary must be set")
+	}
+	if c.MPV.StartTimeout <= 0 || c.MPV.CommandTimeout <= 0 {
+		problems = append(problems, "mpv.start_timeout and mpv.command_timeout must be positive")
+	}
+	if c.MPV.MinBackoff <= 0 || c.MPV.MaxBackoff < c.MPV.MinBackoff {
+		problems = append(problems, "mpv.min_backoff must be positive and not above mpv.max_backoff")
+	}
+
+	for _, name := range c.Metadata.Providers {
+		if !slices.Contains(metadataProviders, name) {
+			problems = append(problems, fmt.Sprintf("metadata.providers: unknown provider %q, known are %s", name, strings.Join(metadataProviders, ", ")))
+		}
+	}
+
+	if c.Metadata.Timeout <= 0 {
+		problems = append(problems, "metadata.timeout must be positive")
+	}
+
+	if c.MusicBrainz.Enabled {
+		u, err := url.Parse(c.MusicBrainz.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "musicbrainz.url must be an http(s) URL")
+		}
+		if c.MusicBrainz.UserAgent == "" {
+			problems = append(problems, "musicbrainz.user_agent must be set")
+		}
+		if c.MusicBrainz.Proxy != "" {
+			u, err := url.Parse(c.MusicBrainz.Proxy)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				problems = append(problems, "musicbrainz.proxy must be a URL")
+			}
+		}
+		if c.MusicBrainz.Timeout <= 0 {
+			problems = append(problems, "musicbrainz.timeout must be positive")
+		}
+		if c.MusicBrainz.RateLimit < 0 || c.MusicBrainz.Retries < 0 {
+			problems = append(problems, "musicbrainz.rate_limit and musicbrainz.retries must not be negative")
+		}
+	}
+
+	if slices.Contains(c.Metadata.Providers, "cddb") {
+		u, err := url.Parse(c.CDDB.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "cddb.url must be an http(s) URL")
+		}
+		if c.CDDB.User == "" || c.CDDB.Host == "" {
+			problems = append(problems, "cddb.user and cddb.host must be set to your email address, GnuDB rejects requests without one")
+		}
+		if c.CDDB.Timeout <= 0 || c.CDDB.MaxMatches <= 0 {
+			problems = append(problems, "cddb.timeout and cddb.max_matches must be positive")
+		}
+	}
+
+	if c.Cache.Enabled {
+		if c.Cache.TTL <= 0 {
+			problems = append(problems, "cache.ttl must be positive")
+		}
+		if c.Cache.MaxEntries <= 0 {
+			problems = append(problems, "cache.max_entries must be positive")
+		}
+	}
+
+	if c.Art.Enabled {
+		u, err := url.Parse(c.Art.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "art.url must be an http(s) URL")
+		}
+		if c.Art.Timeout <= 0 {
+			problems = append(problems, "art.timeout must be positive")
+		}
+		if c.Art.Size <= 0 || c.Art.Size > maxArtSize {
+			problems = append(problems, fmt.Sprintf("art.size must be between 1 and %d", maxArtSize))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func (c *Config) Print(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(c)
+}
+
+type configFlag struct {
+	raw string
+	set bool
+}
+
+func (f *configFlag) String() string {
+	return f.raw
+}
+
+func (f *configFlag) Set(raw string) error {
+	f.raw = raw
+	f.set = true
+	return nil
+}
+
+// walkConfig calls fn for every setting as "<section>.<key>".
+func walkConfig(config *Config, fn func(name string, value reflect.Value)) {
+	sections := reflect.ValueOf(config).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionName := sections.Type().Field(i).Tag.Get("toml")
+
+		for j := 0; j < section.NumField(); j++ {
+			key := section.Type().Field(j).Tag.Get("toml")
+			fn(sectionName+"."+key, section.Field(j))
+		}
+	}
+}
+
+func setConfigValue(value reflect.Value, raw string) error {
+	if _, ok := value.Interface().(time.Duration); ok {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(n))
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported setting type %s", value.Type())
+		}
+
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+
+	return nil
+}
@@ -2,26 +2,37 @@ package main
 
 import (
 	"bufio"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/jacobsa/go-serial/serial"
 )
 
-const (
-	CONTROLLER_PORT = "/dev/ttyACM0"
-)
-
 type Controller struct {
-	port io.ReadWriteCloser
+	port       io.ReadWriteCloser
+	writeDelay time.Duration
+	charset    string
+
+	// writeMu keeps commands whole, the cover is streamed between the
+	// others from its own goroutine, see SendArt.
+	writeMu sync.Mutex
+	art     chan *Artwork
+
+	// fields holds what the display shows, only changes are sent.
+	fields map[string]string
 }
 
-func InitController() (*Controller, error) {
+var errArtReplaced = errors.New("cover replaced by a newer one")
+
+func InitController(config ControllerConfig) (*Controller, error) {
 	options := serial.OpenOptions{
-		PortName:        CONTROLLER_PORT,
-		BaudRate:        115200,
+		PortName:        config.Port,
+		BaudRate:        uint(config.BaudRate),
 		DataBits:        8,
 		StopBits:        1,
 		MinimumReadSize: 4,
@@ -33,9 +44,22 @@ func InitController() (*Controller, error) {
 	}
 
 	port.Write([]byte("player_status|Player OK\r"))
-	time.Sleep(10 * time.Millisecond)
+	time.Sleep(config.WriteDelay)
 
-	return &Controller{port}, nil
+	return newController(port, config), nil
+}
+
+func newController(port io.ReadWriteCloser, config ControllerConfig) *Controller {
+	c := &Controller{
+		port:       port,
+		writeDelay: config.WriteDelay,
+		charset:    config.Charset,
+		art:        make(chan *Artwork, 1),
+		fields:     map[string]string{},
+	}
+	go c.streamArt()
+
+	return c
 }
 
 type KeyCommand struct {
@@ -43,25 +67,99 @@ type KeyCommand struct {
 	Key   string `json:"key"`
 }
 
-func (c *Controller) ListenKeys(keyPresses chan string) {
+func (c *Controller) ListenKeys(keyPresses chan *KeyCommand) {
 	scanner := bufio.NewScanner(c.port)
 	for scanner.Scan() {
 		line := scanner.Text()
-		var command KeyCommand
-		err := json.Unmarshal([]byte(line), &command)
+		command := new(KeyCommand)
+		err := json.Unmarshal([]byte(line), command)
 		if err != nil {
 			fmt.Printf("Error parsing Command JSON: %v", err)
 			continue
 		}
 
 		if command.Key != "none" {
-			keyPresses <- command.Key
+			keyPresses <- command
 		}
 	}
 }
 
+// Text folds s to what the display can show.
+func (c *Controller) Text(s string) string {
+	if c.charset == charsetUTF8 {
+		return s
+	}
+
+	return foldASCII(s)
+}
+
+// SendArt has the cover streamed to the display in the background, nil
+// clears it. A cover still being sent is given up for the new one.
+func (c *Controller) SendArt(artwork *Artwork) {
+	select {
+	case <-c.art:
+	default:
+	}
+	c.art <- artwork
+}
+
+func (c *Controller) streamArt() {
+	for artwork := range c.art {
+		err := c.WriteArt(artwork)
+		if err != nil && !errors.Is(err, errArtReplaced) {
+			fmt.Printf("Failed to send cover: %v\n", err)
+		}
+	}
+}
+
+// WriteArt streams a cover to the display in hex chunks, nil clears it.
+// It stops early when SendArt was given a newer cover.
+func (c *Controller) WriteArt(artwork *Artwork) error {
+	if artwork == nil || len(artwork.Thumbnail) == 0 {
+		return c.WriteCommand(`art_clear|`)
+	}
+
+	err := c.WriteCommand(fmt.Sprintf("art_begin|%dx%d", artwork.Size, artwork.Size))
+	if err != nil {
+		return err
+	}
+
+	for offset := 0; offset < len(artwork.Thumbnail); offset += artChunkSize {
+		if len(c.art) > 0 {
+			return errArtReplaced
+		}
+
+		chunk := artwork.Thumbnail[offset:min(offset+artChunkSize, len(artwork.Thumbnail))]
+		err = c.WriteCommand(fmt.Sprintf("art|%d:%s", offset, hex.EncodeToString(chunk)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.WriteCommand(`art_end|`)
+}
+
+// WriteField sets a line of the display, like "time" or "track", unless it
+// already shows value.
+func (c *Controller) WriteField(field string, value string) error {
+	if shown, ok := c.fields[field]; ok && shown == value {
+		return nil
+	}
+
+	err := c.WriteCommand(field + "|" + value)
+	if err != nil {
+		return err
+	}
+	c.fields[field] = value
+
+	return nil
+}
+
 func (c *Controller) WriteCommand(command string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
 	_, err := c.port.Write([]byte(command + "\r"))
-	time.Sleep(10 * time.Millisecond)
+	time.Sleep(c.writeDelay)
 	return err
 }
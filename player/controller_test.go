@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bufferPort struct {
+	bytes.Buffer
+}
+
+func (b *bufferPort) Close() error {
+	return nil
+}
+
+func TestWriteFieldSendsChanges(t *testing.T) {
+	port := &bufferPort{}
+	c := newController(port, ControllerConfig{Charset: charsetASCII})
+
+	for _, position := range []string{"00:01/04:00", "00:01/04:00", "00:02/04:00"} {
+		c.WriteField("time", position)
+	}
+	c.WriteField("track", "1. Intro")
+
+	want := "time|00:01/04:00\rtime|00:02/04:00\rtrack|1. Intro\r"
+	if got := port.String(); got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+func TestArtistLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		metadata  ClassicalMetadata
+		artist    string
+		wantTrack string
+		want      string
+	}{
+		{"song", ClassicalMetadata{}, "", "1. Song", "Band"},
+		{"song of a compilation", ClassicalMetadata{}, "Singer", "1. Song", "Singer"},
+		{"song performing a work", ClassicalMetadata{Composer: "Songwriter", Work: "Song"}, "", "1. Song", "Band"},
+		{"movement", ClassicalMetadata{Composer: "Beethoven", Work: "Symphony No. 5", Movement: "I. Allegro con brio"}, "", "1. I. Allegro con brio", "Beethoven: Symphony No. 5"},
+		{"movement without composer", ClassicalMetadata{Work: "Suite", Movement: "Prelude"}, "", "1. Prelude", "Suite"},
+	}
+
+	for _, test := range tests {
+		p, _ := newTestPlayer(t, testTOC(t))
+		p.Disc.Artist = "Band"
+		track := p.Disc.Tracks[0]
+		track.Title = "Song"
+		track.Artist = test.artist
+		track.ClassicalMetadata = test.metadata
+
+		c := newController(&bufferPort{}, ControllerConfig{Charset: charsetUTF8})
+		p.UpdateController(c)
+
+		if c.fields["artist"] != test.want || c.fields["track"] != test.wantTrack {
+			t.Errorf("%s: artist %q, track %q, want %q and %q", test.name, c.fields["artist"], c.fields["track"], test.want, test.wantTrack)
+		}
+	}
+}
+
+// output waits until the controller has sent a line with suffix and
+// returns what it sent.
+func output(t *testing.T, c *Controller, port *bufferPort, suffix string) string {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		c.writeMu.Lock()
+		sent := port.String()
+		c.writeMu.Unlock()
+
+		if strings.HasSuffix(sent, suffix) {
+			return sent
+		}
+	}
+
+	t.Fatalf("controller never sent %q", suffix)
+	return ""
+}
+
+func testArtwork() *Artwork {
+	return &Artwork{DiscID: "test-disc", Size: 72, Thumbnail: make([]byte, 72*72*2)}
+}
+
+func TestSendArtInBackground(t *testing.T) {
+	port := &bufferPort{}
+	c := newController(port, ControllerConfig{Charset: charsetASCII, WriteDelay: 5 * time.Millisecond})
+
+	start := time.Now()
+	c.SendArt(testArtwork())
+	c.WriteField("track", "1. Intro")
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("sending a cover and a line took %v", elapsed)
+	}
+
+	sent := output(t, c, port, "art_end|\r")
+	if chunks := strings.Count(sent, "\rart|"); chunks != 81 {
+		t.Errorf("sent %d chunks, want 81", chunks)
+	}
+	if strings.Index(sent, "track|1. Intro\r") > strings.Index(sent, "art_end|") {
+		t.Error("the track line waited for the whole cover")
+	}
+}
+
+func TestSendArtReplaced(t *testing.T) {
+	port := &bufferPort{}
+	c := newController(port, ControllerConfig{Charset: charsetASCII, WriteDelay: 5 * time.Millisecond})
+
+	c.SendArt(testArtwork())
+	time.Sleep(20 * time.Millisecond)
+	c.SendArt(nil)
+
+	sent := output(t, c, port, "art_clear|\r")
+	if strings.Contains(sent, "art_end|") {
+		t.Error("replaced cover was sent to the end")
+	}
+}
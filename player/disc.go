@@ -2,144 +2,203 @@ package main
 
 import (
 	"fmt"
-	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
-	"time"
 )
 
 type Disc struct {
+	ID     string   `json:"id"`
+	TOC    string   `json:"toc"`
+	MCN    string   `json:"mcn,omitempty"` // UPC/EAN barcode
 	Artist string   `json:"artist"`
 	Title  string   `json:"title"`
 	Tracks []*Track `json:"tracks"`
 
-	Size int64 `json:"size"`
+	// Hidden is audio in the pregap of track 1, shown as track 0.
+	Hidden *Track `json:"hidden,omitempty"`
+
+	Year           string `json:"year,omitempty"`
+	Label          string `json:"label,omitempty"`
+	CatalogNumber  string `json:"catalog_number,omitempty"`
+	Disambiguation string `json:"disambiguation,omitempty"`
+	Media          int    `json:"media,omitempty"` // number of media in the release
+
+	// Sources maps "title", "artist", "year", "label", "catalog_number",
+	// "disambiguation" and "track.<n>.title", "track.<n>.artist" or
+	// "track.<n>.recording" and "track.<n>.work" to the metadata provider that
+	// supplied the value, "cache" when it came from the metadata cache.
+	Sources map[string]string `json:"sources,omitempty"`
+
+	// Candidates are the releases the disc could be, Candidate is the one
+	// shown.
+	Candidates []*ReleaseCandidate `json:"candidates,omitempty"`
+	Candidate  int                 `json:"candidate"`
+
+	// Approximate is set when some metadata comes from a release that
+	// only looks like the disc.
+	Approximate bool `json:"approximate"`
 }
 
-func monitorDiscSize(s chan int64) {
-	for {
-		size, _ := getDiscSize()
-		if size > 0 {
-			s <- size
-		}
+// Copy returns a deep copy of the disc, so that a cached disc is never
+// modified by the player and the other way around.
+func (d *Disc) Copy() *Disc {
+	disc := *d
+	disc.Sources = make(map[string]string, len(d.Sources))
+	for field, source := range d.Sources {
+		disc.Sources[field] = source
+	}
 
-		time.Sleep(500 * time.Millisecond)
+	disc.Candidates = make([]*ReleaseCandidate, len(d.Candidates))
+	for i, candidate := range d.Candidates {
+		c := *candidate
+		disc.Candidates[i] = &c
 	}
-}
 
-func getDiscSize() (int64, error) {
-	cmd := exec.Command("blockdev", "--getsize64", CDDevice)
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, err
+	disc.Tracks = make([]*Track, len(d.Tracks))
+	for i, track := range d.Tracks {
+		t := *track
+		t.Indexes = slices.Clone(track.Indexes)
+		disc.Tracks[i] = &t
 	}
 
-	sizeStr := strings.TrimSpace(string(output))
-	size, err := strconv.ParseInt(sizeStr, 10, 64)
-	if err != nil {
-		return 0, err
+	if d.Hidden != nil {
+		hidden := *d.Hidden
+		disc.Hidden = &hidden
 	}
 
-	return size, nil
+	return &disc
 }
 
-func createDisc(TOC string, size int64) (*Disc, error) {
+// parseTOC splits a TOC in the "first last leadout offsets..." form
+// libdiscid gives, offsets and leadout are in frames.
+func parseTOC(TOC string) (firstTrack int, lastTrack int, leadout int, offsets []int, err error) {
 	parts := strings.Split(TOC, " ")
 	if len(parts) < 3 {
-		return nil, fmt.Errorf("invalid TOC format")
+		return 0, 0, 0, nil, fmt.Errorf("invalid TOC format")
 	}
 
-	firstTrack, err := strconv.Atoi(parts[0])
+	firstTrack, err = strconv.Atoi(parts[0])
 	if err != nil {
-		return nil, fmt.Errorf("invalid first track number: %v", err)
+		return 0, 0, 0, nil, fmt.Errorf("invalid first track number: %v", err)
 	}
 
-	lastTrack, err := strconv.Atoi(parts[1])
+	lastTrack, err = strconv.Atoi(parts[1])
 	if err != nil {
-		return nil, fmt.Errorf("invalid last track number: %v", err)
+		return 0, 0, 0, nil, fmt.Errorf("invalid last track number: %v", err)
 	}
 
-	endOfDisc, err := strconv.Atoi(parts[2])
+	leadout, err = strconv.Atoi(parts[2])
 	if err != nil {
-		return nil, fmt.Errorf("invalid end of disc value: %v", err)
+		return 0, 0, 0, nil, fmt.Errorf("invalid end of disc value: %v", err)
 	}
 
 	startFrames := parts[3:]
 	numTracks := lastTrack - firstTrack + 1
 
 	if len(startFrames) != numTracks {
-		return nil, fmt.Errorf("TOC length mismatch, expected %d frames but got %d", numTracks, len(startFrames))
+		return 0, 0, 0, nil, fmt.Errorf("TOC length mismatch, expected %d frames but got %d", numTracks, len(startFrames))
 	}
 
-	tracks := make([]*Track, numTracks)
-	for i := 0; i < numTracks; i++ {
-		beginFrame, err := strconv.Atoi(startFrames[i])
+	offsets = make([]int, numTracks)
+	for i, frame := range startFrames {
+		offsets[i], err = strconv.Atoi(frame)
 		if err != nil {
-			return nil, fmt.Errorf("invalid begin frame for track %d: %v", i+1, err)
+			return 0, 0, 0, nil, fmt.Errorf("invalid begin frame for track %d: %v", firstTrack+i, err)
 		}
+	}
+
+	return firstTrack, lastTrack, leadout, offsets, nil
+}
+
+// createDisc builds a disc with placeholder titles from a TOC string, which
+// only has audio tracks.
+func createDisc(TOC string) (*Disc, error) {
+	firstTrack, lastTrack, leadout, offsets, err := parseTOC(TOC)
+	if err != nil {
+		return nil, err
+	}
+
+	toc := &DiscTOC{First: firstTrack, Last: lastTrack, Leadout: leadout}
+	for i, offset := range offsets {
+		toc.Tracks = append(toc.Tracks, TOCTrack{Number: firstTrack + i, Offset: offset})
+	}
+
+	return newDisc(toc), nil
+}
+
+// playbackTime is the playback position of a TOC frame in ms. Positions
+// count from index 1 of the first track, at firstFrame, with neither the
+// lead-in nor the pregap before it.
+func playbackTime(frame int, firstFrame int) int {
+	return (frame - firstFrame) * 1000 / 75
+}
+
+// newDisc builds a disc with placeholder titles from the tracks of the disc
+// ID, see DiscTOC.audioEnd. A track before a session boundary ends one
+// session gap before the next session.
+func newDisc(toc *DiscTOC) *Disc {
+	lastTrack, endOfDisc := toc.audioEnd()
 
-		var endFrame int
-		if i < numTracks-1 {
-			nextBeginFrame, err := strconv.Atoi(startFrames[i+1])
-			if err != nil {
-				return nil, fmt.Errorf("invalid next begin frame for track %d: %v", i+2, err)
+	var startFrame int
+	if len(toc.Tracks) > 0 {
+		startFrame = toc.Tracks[0].Offset
+	}
+
+	var tracks []*Track
+	for i, tocTrack := range toc.Tracks {
+		if tocTrack.Number > lastTrack {
+			break
+		}
+
+		beginFrame := tocTrack.Offset
+
+		endFrame := endOfDisc
+		if tocTrack.Number < lastTrack {
+			endFrame = toc.Tracks[i+1].Offset
+			if endFrame == toc.LastSession {
+				endFrame -= sessionGap
 			}
-			endFrame = nextBeginFrame
-		} else {
-			endFrame = endOfDisc
 		}
 
-		length := (endFrame - beginFrame) * 1000 / 75
+		length := playbackTime(endFrame, beginFrame)
 
-		tracks[i] = &Track{
-			Title:  fmt.Sprintf("Track %d", firstTrack+i),
-			Number: strconv.Itoa(firstTrack + i),
-			Offset: beginFrame * 1000 / 75,
-			Length: length,
+		title := fmt.Sprintf("Track %d", tocTrack.Number)
+		if tocTrack.Data {
+			title = "Data Track"
 		}
+
+		tracks = append(tracks, &Track{
+			Title:  title,
+			Number: strconv.Itoa(tocTrack.Number),
+			Offset: playbackTime(beginFrame, startFrame),
+			Length: length,
+			Data:   tocTrack.Data,
+		})
 	}
 
-	disc := &Disc{
+	return &Disc{
 		Artist: "Unknown Artist",
 		Title:  "Unknown Album",
 		Tracks: tracks,
-		Size:   size,
 	}
-
-	return disc, nil
 }
 
-func createAndIdentifyDisk(size int64) (*Disc, error) {
-	discID, TOC, err := getDiscIDAndTOC()
+func readDisc(device string) (*Disc, error) {
+	toc, err := readTOC(device)
 	if err != nil {
 		return nil, err
 	}
 
-	disc, err := createDisc(TOC, size)
+	TOC := toc.String()
+	discID, err := musicBrainzDiscID(TOC)
 	if err != nil {
 		return nil, err
 	}
 
-	discInfo, err := getDiscInfo(discID)
-	if err != nil {
-		fmt.Printf("failed to get disc info: %v\n", err)
-		return disc, nil
-	}
-
-	if discInfo != nil && len(discInfo.Releases) > 0 {
-		disc.Title = discInfo.Releases[0].Title
-		if len(discInfo.Releases[0].ArtistCredit) > 0 {
-			disc.Artist = discInfo.Releases[0].ArtistCredit[0].Name
-		}
-
-		if len(discInfo.Releases[0].Media) > 0 {
-			for i, track := range discInfo.Releases[0].Media[0].Tracks {
-				if i < len(disc.Tracks) {
-					disc.Tracks[i].Title = track.Title
-				}
-			}
-		}
-	}
+	disc := newDisc(toc)
+	disc.ID = discID
+	disc.TOC = TOC
 
 	return disc, nil
 }
@@ -1,80 +1,611 @@
 package main
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
-
-	"go.uploadedlobster.com/discid"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-const musicBrainzURL = "https://musicbrainz.org/ws/2/"
-
 type DiscIDResponse struct {
-	ID       string `json:"id"`
-	Releases []struct {
-		Title        string `json:"title"`
-		ArtistCredit []struct {
-			Name string `json:"name"`
-		} `json:"artist-credit"`
-		Media []struct {
-			Tracks []struct {
-				Title  string `json:"title"`
-				Number string `json:"number"`
-			} `json:"tracks"`
-		} `json:"media"`
-	} `json:"releases"`
-}
-
-func getDiscInfo(discID string) (*DiscIDResponse, error) {
-	requestURL := fmt.Sprintf("%sdiscid/%s?inc=recordings+artists&fmt=json", musicBrainzURL, url.PathEscape(discID))
-
-	req, err := http.NewRequest("GET", requestURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	ID       string      `json:"id"`
+	Releases []MBRelease `json:"releases"`
+
+	// A disc ID or TOC only known from a CD stub gets the stub instead of
+	// releases.
+	Title  string    `json:"title"`
+	Artist string    `json:"artist"`
+	Tracks []MBTrack `json:"tracks"`
+}
+
+type MBRelease struct {
+	ID             string         `json:"id"`
+	Title          string         `json:"title"`
+	Disambiguation string         `json:"disambiguation"`
+	Status         string         `json:"status"`
+	Country        string         `json:"country"`
+	Date           string         `json:"date"`
+	Barcode        string         `json:"barcode"`
+	ArtistCredit   MBArtistCredit `json:"artist-credit"`
+	LabelInfo      []MBLabelInfo  `json:"label-info"`
+	Media          []MBMedium     `json:"media"`
+	Relations      []MBRelation   `json:"relations"`
+
+	TextRepresentation struct {
+		Language string `json:"language"`
+		Script   string `json:"script"` // ISO 15924, "Latn" for Latin
+	} `json:"text-representation"`
+}
+
+// MBArtistCredit lists the credited artists, each followed by the phrase
+// joining it to the next one, like " feat. ".
+type MBArtistCredit []struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+	Artist     struct {
+		Name    string    `json:"name"`
+		Aliases []MBAlias `json:"aliases"`
+	} `json:"artist"`
+}
+
+type MBAlias struct {
+	Name    string `json:"name"`
+	Locale  string `json:"locale"`
+	Primary bool   `json:"primary"`
+	Type    string `json:"type"`
+}
+
+func (c MBArtistCredit) String() string {
+	var name strings.Builder
+	for _, credit := range c {
+		name.WriteString(credit.Name)
+		name.WriteString(credit.JoinPhrase)
 	}
 
-	req.Header.Set("User-Agent", "OSCDP/v0.1 ( danilo.fragoso@gmail.com )")
+	return name.String()
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+type MBLabelInfo struct {
+	CatalogNumber string `json:"catalog-number"`
+	Label         *struct {
+		Name string `json:"name"`
+	} `json:"label"`
+}
+
+type MBMedium struct {
+	Position int    `json:"position"`
+	Format   string `json:"format"`
+	Discs    []struct {
+		ID      string `json:"id"`
+		Sectors int    `json:"sectors"`
+		Offsets []int  `json:"offsets"`
+	} `json:"discs"`
+	Tracks []MBTrack `json:"tracks"`
+}
+
+type MBTrack struct {
+	Title        string         `json:"title"`
+	Number       string         `json:"number"`
+	Length       int            `json:"length"` // in ms, 0 when unknown
+	ArtistCredit MBArtistCredit `json:"artist-credit"`
+	Artist       string         `json:"artist"` // on CD stub tracks instead
+	Recording    struct {
+		ID        string       `json:"id"`
+		ISRCs     []string     `json:"isrcs"`
+		Relations []MBRelation `json:"relations"`
+	} `json:"recording"`
+}
+
+// MusicBrainzClient talks to the MusicBrainz web service or a mirror of it.
+// Requests from all clients share one rate limit, 429 and 503 responses are
+// retried with exponential backoff or after the time the server asks for.
+type MusicBrainzClient struct {
+	config    MusicBrainzConfig
+	userAgent string
+	client    *http.Client
+}
+
+const (
+	musicBrainzMinBackoff = 1 * time.Second
+	musicBrainzMaxBackoff = 30 * time.Second
+)
+
+// musicBrainzLimiter spaces out requests to follow the MusicBrainz rate
+// limit of one request per second per client.
+var musicBrainzLimiter = &rateLimiter{}
+
+func NewMusicBrainzClient(config MusicBrainzConfig) *MusicBrainzClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		// Validated with the config.
+		proxy, _ := url.Parse(config.Proxy)
+		transport.Proxy = http.ProxyURL(proxy)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return nil, fmt.Errorf("rate limit exceeded, try again later")
+	userAgent := config.UserAgent
+	if config.Contact != "" {
+		userAgent += " ( " + config.Contact + " )"
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+
+	return &MusicBrainzClient{
+		config:    config,
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: config.Timeout, Transport: transport},
 	}
+}
+
+// DiscID looks up the releases with the disc ID, a disc MusicBrainz does
+// not know has no releases.
+func (c *MusicBrainzClient) DiscID(ctx context.Context, discID string) (*DiscIDResponse, error) {
+	return c.discID(ctx, discID, nil)
+}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+// TOC looks up the releases with a TOC close to the given one, in the
+// "first last leadout offsets..." form libdiscid gives.
+func (c *MusicBrainzClient) TOC(ctx context.Context, toc string) (*DiscIDResponse, error) {
+	return c.discID(ctx, "-", url.Values{"toc": {strings.ReplaceAll(toc, " ", "+")}})
+}
+
+// Release looks up a release with the given subqueries.
+func (c *MusicBrainzClient) Release(ctx context.Context, releaseID string, inc string) (*MBRelease, error) {
+	query := url.Values{}
+	query.Set("inc", inc)
+	query.Set("fmt", "json")
+
+	var release MBRelease
+	found, err := c.get(ctx, "release/"+url.PathEscape(releaseID), query, &release)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("release %s not found", releaseID)
 	}
 
+	return &release, nil
+}
+
+func (c *MusicBrainzClient) discID(ctx context.Context, discID string, query url.Values) (*DiscIDResponse, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("inc", "recordings+artists+artist-credits+aliases+labels+media+discids+isrcs")
+	query.Set("cdstubs", "yes")
+	query.Set("fmt", "json")
+
 	var discResponse DiscIDResponse
-	err = json.Unmarshal(bodyBytes, &discResponse)
+	found, err := c.get(ctx, "discid/"+url.PathEscape(discID), query, &discResponse)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		return nil, err
+	}
+	if !found {
+		return &DiscIDResponse{ID: discID}, nil
 	}
 
 	return &discResponse, nil
 }
 
-var (
-	CDDevice = "/dev/sr0"
-)
+func (r *DiscIDResponse) IsStub() bool {
+	return len(r.Releases) == 0 && len(r.Tracks) > 0
+}
+
+// get fetches a path below the base URL into out and reports false when
+// it does not exist.
+func (c *MusicBrainzClient) get(ctx context.Context, path string, query url.Values, out any) (bool, error) {
+	// url.Values would escape the +s separating inc values.
+	requestURL := strings.TrimSuffix(c.config.URL, "/") + "/" + path + "?" + strings.ReplaceAll(query.Encode(), "%2B", "+")
+
+	backoff := musicBrainzMinBackoff
+	for attempt := 0; ; attempt++ {
+		err := musicBrainzLimiter.wait(ctx, c.config.RateLimit)
+		if err != nil {
+			return false, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("User-Agent", c.userAgent)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return false, fmt.Errorf("failed to make request: %w", err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return false, fmt.Errorf("failed to read response body: %w", err)
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.Unmarshal(body, out)
+			if err != nil {
+				return false, fmt.Errorf("failed to parse response: %w", err)
+			}
+			return true, nil
+
+		case http.StatusNotFound:
+			return false, nil
+
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			if attempt >= c.config.Retries {
+				return false, fmt.Errorf("giving up after %d attempts: %s", attempt+1, resp.Status)
+			}
+
+			delay := backoff
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = retryAfter
+			}
+			backoff = min(backoff*2, musicBrainzMaxBackoff)
+
+			fmt.Printf("MusicBrainz answered %s, retrying in %v\n", resp.Status, delay)
+			musicBrainzLimiter.delay(delay)
+
+		default:
+			return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as a date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+
+	return 0, false
+}
+
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the caller may send a request, callers are let through
+// at most once per interval.
+func (l *rateLimiter) wait(ctx context.Context, interval time.Duration) error {
+	l.mu.Lock()
+	at := l.next
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delay holds back all requests for at least d.
+func (l *rateLimiter) delay(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if at := time.Now().Add(d); at.After(l.next) {
+		l.next = at
+	}
+}
+
+type MusicBrainzProvider struct {
+	config MusicBrainzConfig
+	client *MusicBrainzClient
+
+	// latin prefers Latin script names for displays that cannot show
+	// others.
+	latin bool
+}
+
+var _ MetadataProvider = (*MusicBrainzProvider)(nil)
 
-func getDiscIDAndTOC() (string, string, error) {
-	disc, err := discid.ReadFeatures(CDDevice, discid.FeatureRead|discid.FeatureMCN)
+func NewMusicBrainzProvider(config MusicBrainzConfig, latin bool) *MusicBrainzProvider {
+	return &MusicBrainzProvider{config: config, client: NewMusicBrainzClient(config), latin: latin}
+}
+
+func (m *MusicBrainzProvider) Name() string {
+	return "musicbrainz"
+}
+
+// Lookup returns every release with the disc ID. Unknown discs are looked
+// up by TOC, which only finds approximate matches.
+func (m *MusicBrainzProvider) Lookup(ctx context.Context, query *DiscQuery) ([]*ReleaseCandidate, error) {
+	discInfo, err := m.client.DiscID(ctx, query.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(discInfo.Releases) == 0 && !discInfo.IsStub() && query.TOC != "" {
+		discInfo, err = m.client.TOC(ctx, query.TOC)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if m.latin {
+		latinAliases(discInfo)
+	}
+
+	candidates := sortCandidates(releaseCandidates(m.Name(), m.config, query, discInfo))
+
+	// Only the best release, every lookup waits for the rate limit.
+	if m.config.Works && len(candidates) > 0 && candidates[0].ReleaseID != "" {
+		err = m.addWorks(ctx, candidates[0])
+		if err != nil {
+			fmt.Printf("Failed to look up works of release %s: %v\n", candidates[0].ReleaseID, err)
+		}
+	}
+
+	if m.latin && len(candidates) > 0 && candidates[0].ReleaseID != "" {
+		latin, err := m.latinCandidate(ctx, candidates[0])
+		if err != nil {
+			fmt.Printf("Failed to look up pseudo-release of %s: %v\n", candidates[0].ReleaseID, err)
+		}
+		if latin != nil {
+			candidates = append([]*ReleaseCandidate{latin}, candidates...)
+		}
+	}
+
+	return candidates, nil
+}
+
+// releaseCandidates returns a candidate for every medium that holds the
+// disc, found by disc ID or, failing that, by track count and lengths.
+// Candidates are ranked by the barcode matching the disc's MCN, by the
+// recordings having the disc's ISRCs and by the configured country, format
+// and status preferences. CD stubs are less trusted than releases.
+func releaseCandidates(provider string, config MusicBrainzConfig, query *DiscQuery, discInfo *DiscIDResponse) []*ReleaseCandidate {
+	if discInfo.IsStub() {
+		if len(discInfo.Tracks) != query.Tracks {
+			return nil
+		}
+
+		candidate := &ReleaseCandidate{
+			Provider:    provider,
+			Confidence:  0.3 * lengthScore(query.Lengths, discInfo.Tracks),
+			Approximate: true,
+			Format:      "CD stub",
+			Title:       discInfo.Title,
+			Artist:      discInfo.Artist,
+		}
+		for _, track := range discInfo.Tracks {
+			trackMetadata := TrackMetadata{Title: track.Title, Length: track.Length}
+			if track.Artist != "" && track.Artist != discInfo.Artist {
+				trackMetadata.Artist = track.Artist
+			}
+			candidate.Tracks = append(candidate.Tracks, trackMetadata)
+		}
+
+		return []*ReleaseCandidate{candidate}
+	}
+
+	byID := false
+	for _, release := range discInfo.Releases {
+		for _, medium := range release.Media {
+			byID = byID || medium.HasDisc(query.ID)
+		}
+	}
+
+	var candidates []*ReleaseCandidate
+	for _, release := range discInfo.Releases {
+		for _, medium := range release.Media {
+			match := 0.0
+			if medium.HasDisc(query.ID) {
+				match = 1
+			} else if !byID && len(medium.Tracks) == query.Tracks {
+				match = 0.5 * lengthScore(query.Lengths, medium.Tracks)
+			}
+			if match == 0 {
+				continue
+			}
+
+			preference := preferenceScore(config.Countries, release.Country) +
+				preferenceScore(config.Formats, medium.Format) +
+				preferenceScore(config.Statuses, release.Status)
+
+			score := 0.5 + 0.05*preference + 0.15*isrcScore(query.ISRCs, medium.Tracks)
+			if query.MCN != "" && sameBarcode(query.MCN, release.Barcode) {
+				score += 0.2
+			}
+
+			candidate := &ReleaseCandidate{
+				Provider:    provider,
+				Confidence:  match * score,
+				Approximate: match < 1,
+				ReleaseID:   release.ID,
+				Barcode:     release.Barcode,
+				Medium:      medium.Position,
+				Country:     release.Country,
+				Format:      medium.Format,
+				Status:      release.Status,
+				Date:        release.Date,
+				Media:       len(release.Media),
+
+				Title:          release.Title,
+				Artist:         release.ArtistCredit.String(),
+				Disambiguation: release.Disambiguation,
+			}
+
+			if len(release.Date) >= 4 {
+				candidate.Year = release.Date[:4]
+			}
+
+			for _, info := range release.LabelInfo {
+				if candidate.Label == "" && info.Label != nil {
+					candidate.Label = info.Label.Name
+				}
+				if candidate.CatalogNumber == "" {
+					candidate.CatalogNumber = info.CatalogNumber
+				}
+			}
+
+			for _, track := range medium.Tracks {
+				trackMetadata := TrackMetadata{
+					Title:     track.Title,
+					Recording: track.Recording.ID,
+					Length:    track.Length,
+				}
+				if artist := track.ArtistCredit.String(); artist != candidate.Artist {
+					trackMetadata.Artist = artist
+				}
+				candidate.Tracks = append(candidate.Tracks, trackMetadata)
+			}
+
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates
+}
+
+// sameBarcode compares an MCN, which is an EAN-13, with a release barcode
+// that may be the same number as a 12 digit UPC.
+func sameBarcode(mcn string, barcode string) bool {
+	return barcode != "" && strings.TrimLeft(mcn, "0") == strings.TrimLeft(barcode, "0")
+}
+
+// isrcScore is the share of the disc's ISRCs found on the recordings of
+// the corresponding tracks, 0 when the disc has none.
+func isrcScore(isrcs []string, tracks []MBTrack) float64 {
+	known, confirmed := 0, 0
+	for i, isrc := range isrcs {
+		if isrc == "" || i >= len(tracks) {
+			continue
+		}
+
+		known++
+		if slices.Contains(tracks[i].Recording.ISRCs, isrc) {
+			confirmed++
+		}
+	}
+
+	if known == 0 {
+		return 0
+	}
+
+	return float64(confirmed) / float64(known)
+}
+
+// lengthScore rates how well track lengths match those on the disc, from 1
+// for identical lengths to 0 for tracks more than 10s off.
+func lengthScore(lengths []int, tracks []MBTrack) float64 {
+	if len(lengths) != len(tracks) {
+		return 0
+	}
+
+	score, known := 0.0, 0
+	for i, track := range tracks {
+		if track.Length == 0 {
+			continue
+		}
+
+		diff := math.Abs(float64(track.Length - lengths[i]))
+		score += math.Max(0, 1-diff/10000)
+		known++
+	}
+
+	if known == 0 {
+		return 0.5
+	}
+
+	return score / float64(known)
+}
+
+func (m *MBMedium) HasDisc(discID string) bool {
+	for _, disc := range m.Discs {
+		if disc.ID == discID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortCandidates orders candidates by confidence, most confident first.
+func sortCandidates(candidates []*ReleaseCandidate) []*ReleaseCandidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+
+	return candidates
+}
+
+// preferenceScore is 1 for the first preferred value, falling towards 0
+// for later ones and 0 for values that are not preferred.
+func preferenceScore(preferred []string, value string) float64 {
+	for i, p := range preferred {
+		if strings.EqualFold(p, value) {
+			return float64(len(preferred)-i) / float64(len(preferred))
+		}
+	}
+
+	return 0
+}
+
+// getDiscIDAndTOC reads the TOC of the disc in the drive and computes its
+// MusicBrainz disc ID.
+func getDiscIDAndTOC(device string) (string, string, error) {
+	toc, err := readTOC(device)
+	if err != nil {
+		return "", "", err
+	}
+
+	TOC := toc.String()
+	discID, err := musicBrainzDiscID(TOC)
 	if err != nil {
 		return "", "", err
 	}
-	defer disc.Close()
-	return disc.ID(), disc.TOCString(), nil
+
+	return discID, TOC, nil
+}
+
+// musicBrainzDiscID computes the disc ID of a TOC: the SHA-1 of the first
+// and last track numbers and of the leadout and 99 track offsets in hex,
+// in base64 with "+", "/" and "=" replaced by ".", "_" and "-".
+func musicBrainzDiscID(TOC string) (string, error) {
+	first, last, leadout, offsets, err := parseTOC(TOC)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%02X%02X%08X", first, last, leadout)
+	for track := 1; track <= 99; track++ {
+		offset := 0
+		if track >= first && track <= last {
+			offset = offsets[track-first]
+		}
+		fmt.Fprintf(hash, "%08X", offset)
+	}
+
+	discID := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	return strings.NewReplacer("+", ".", "/", "_", "=", "-").Replace(discID), nil
 }
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// musicBrainzServer answers with the given statuses in turn, the last one
+// repeatedly, and records when requests came in.
+type musicBrainzServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []time.Time
+}
+
+func newMusicBrainzServer(t *testing.T, retryAfter string, statuses ...int) *musicBrainzServer {
+	s := &musicBrainzServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, time.Now())
+		status := statuses[min(len(s.requests), len(statuses))-1]
+		s.mu.Unlock()
+
+		if status != http.StatusOK {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"id": "disc", "releases": []}`))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// gaps returns the time between requests. They are taken when the server
+// sees them, so the gaps may be a bit shorter than the client spaced them.
+func (s *musicBrainzServer) gaps() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := slices.Clone(s.requests)
+	slices.SortFunc(requests, func(a time.Time, b time.Time) int { return a.Compare(b) })
+
+	var gaps []time.Duration
+	for i := 1; i < len(requests); i++ {
+		gaps = append(gaps, requests[i].Sub(requests[i-1]))
+	}
+
+	return gaps
+}
+
+func testMusicBrainzConfig(url string) MusicBrainzConfig {
+	config := DefaultConfig().MusicBrainz
+	config.URL = url
+	config.RateLimit = 50 * time.Millisecond
+
+	return config
+}
+
+func TestMusicBrainzRetriesBusyResponses(t *testing.T) {
+	server := newMusicBrainzServer(t, "1", http.StatusServiceUnavailable, http.StatusOK)
+	config := testMusicBrainzConfig(server.URL)
+	config.Retries = 2
+
+	_, err := NewMusicBrainzClient(config).DiscID(context.Background(), "disc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gaps := server.gaps()
+	if len(gaps) != 1 {
+		t.Fatalf("got %d requests, want 2", len(gaps)+1)
+	}
+	if gaps[0] < time.Second {
+		t.Errorf("retried after %v, the server asked for 1s", gaps[0])
+	}
+}
+
+func TestMusicBrainzGivesUp(t *testing.T) {
+	server := newMusicBrainzServer(t, "0", http.StatusTooManyRequests)
+	config := testMusicBrainzConfig(server.URL)
+	config.Retries = 2
+
+	_, err := NewMusicBrainzClient(config).DiscID(context.Background(), "disc")
+	if err == nil {
+		t.Fatal("busy server did not fail the lookup")
+	}
+
+	gaps := server.gaps()
+	if len(gaps) != config.Retries {
+		t.Errorf("got %d requests, want %d", len(gaps)+1, config.Retries+1)
+	}
+	for _, gap := range gaps {
+		if gap < config.RateLimit*8/10 {
+			t.Errorf("retried after %v, sooner than the rate limit of %v", gap, config.RateLimit)
+		}
+	}
+}
+
+func TestMusicBrainzRateLimitIsShared(t *testing.T) {
+	server := newMusicBrainzServer(t, "0", http.StatusOK)
+	config := testMusicBrainzConfig(server.URL)
+	config.RateLimit = 100 * time.Millisecond
+
+	// Every provider and the cover art have their own client.
+	clients := []*MusicBrainzClient{NewMusicBrainzClient(config), NewMusicBrainzClient(config)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(client *MusicBrainzClient) {
+			defer wg.Done()
+			_, err := client.DiscID(context.Background(), "disc")
+			if err != nil {
+				t.Error(err)
+			}
+		}(clients[i%len(clients)])
+	}
+	wg.Wait()
+
+	gaps := server.gaps()
+	if len(gaps) != 5 {
+		t.Fatalf("got %d requests, want 6", len(gaps)+1)
+	}
+	for _, gap := range gaps {
+		if gap < config.RateLimit*8/10 {
+			t.Errorf("requests %v apart, the rate limit is %v", gap, config.RateLimit)
+		}
+	}
+}
+
+func TestMusicBrainzRateLimitCancel(t *testing.T) {
+	limiter := &rateLimiter{}
+	limiter.delay(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.wait(ctx, time.Second); err == nil {
+		t.Error("wait returned before the delay without being cancelled")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 0, true}, // in the past
+	}
+
+	for _, test := range tests {
+		got, ok := parseRetryAfter(test.value)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// boxSetDiscs is a two disc set in two pressings, with a bootleg, a
+// DualDisc reissue and a vinyl release of disc 2.
+const boxSetDiscs = `{"id": "disc-2", "releases": [
+	{"id": "box-eu", "title": "Box", "country": "XE", "status": "Official", "barcode": "0123456789012", "media": [
+		{"position": 1, "format": "CD", "discs": [{"id": "disc-1"}], "tracks": [{"title": "A1"}, {"title": "A2"}]},
+		{"position": 2, "format": "CD", "discs": [{"id": "disc-2"}], "tracks": [{"title": "B1"}, {"title": "B2"}]}]},
+	{"id": "box-us", "title": "Box", "country": "US", "status": "Official", "media": [
+		{"position": 1, "format": "CD", "discs": [{"id": "disc-1"}], "tracks": [{"title": "A1"}, {"title": "A2"}]},
+		{"position": 2, "format": "CD", "discs": [{"id": "disc-2"}], "tracks": [{"title": "B1"}, {"title": "B2"}]}]},
+	{"id": "bootleg", "title": "Box Live", "country": "XE", "status": "Bootleg", "media": [
+		{"position": 1, "format": "CD", "discs": [{"id": "disc-2"}], "tracks": [{"title": "B1"}, {"title": "B2"}]}]},
+	{"id": "dualdisc", "title": "Box Reissue", "status": "Official", "barcode": "5012345678900", "media": [
+		{"position": 1, "format": "DualDisc", "discs": [{"id": "disc-2"}], "tracks": [{"title": "B1"}, {"title": "B2"}]}]},
+	{"id": "vinyl", "title": "Box", "country": "XE", "status": "Official", "media": [
+		{"position": 1, "format": "12\" Vinyl", "tracks": [{"title": "B1"}, {"title": "B2"}]}]}
+]}`
+
+func parseDiscIDResponse(t *testing.T, data string) *DiscIDResponse {
+	t.Helper()
+
+	var discInfo DiscIDResponse
+	err := json.Unmarshal([]byte(data), &discInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &discInfo
+}
+
+func TestReleaseCandidates(t *testing.T) {
+	config := DefaultConfig().MusicBrainz
+	config.Countries = []string{"XE", "US"}
+
+	tests := []struct {
+		name  string
+		query DiscQuery
+		want  []string // release/medium, best first
+	}{
+		{
+			name:  "by disc ID and preferences",
+			query: DiscQuery{ID: "disc-2", Tracks: 2},
+			want:  []string{"box-eu/2", "box-us/2", "bootleg/1", "dualdisc/1"},
+		},
+		{
+			name:  "barcode matching the MCN",
+			query: DiscQuery{ID: "disc-2", Tracks: 2, MCN: "5012345678900"},
+			want:  []string{"dualdisc/1", "box-eu/2", "box-us/2", "bootleg/1"},
+		},
+		{
+			name:  "other disc of the set",
+			query: DiscQuery{ID: "disc-1", Tracks: 2},
+			want:  []string{"box-eu/1", "box-us/1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candidates := sortCandidates(releaseCandidates("musicbrainz", config, &test.query, parseDiscIDResponse(t, boxSetDiscs)))
+
+			var got []string
+			for _, candidate := range candidates {
+				got = append(got, fmt.Sprintf("%s/%d", candidate.ReleaseID, candidate.Medium))
+				if candidate.Approximate {
+					t.Errorf("%s/%d found by disc ID is approximate", candidate.ReleaseID, candidate.Medium)
+				}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("candidates = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReleaseCandidatesTakeTheMediumTracks(t *testing.T) {
+	query := &DiscQuery{ID: "disc-2", Tracks: 2}
+	candidates := releaseCandidates("musicbrainz", DefaultConfig().MusicBrainz, query, parseDiscIDResponse(t, boxSetDiscs))
+
+	candidate := candidates[0]
+	if candidate.ReleaseID != "box-eu" || candidate.Media != 2 || candidate.Tracks[0].Title != "B1" {
+		t.Errorf("first candidate is %s with %d media and track 1 %q, want disc 2 of box-eu", candidate.ReleaseID, candidate.Media, candidate.Tracks[0].Title)
+	}
+}
+
+func TestSwitchCandidates(t *testing.T) {
+	p, _ := newTestPlayer(t, parseTestTOC(t, "1 2 40000 150 20000"))
+	query := &DiscQuery{ID: "disc-2", Tracks: 2}
+	p.Disc.SetCandidates(sortCandidates(releaseCandidates("musicbrainz", DefaultConfig().MusicBrainz, query, parseDiscIDResponse(t, boxSetDiscs))))
+
+	// Album titles of the candidates, in order, after each long press.
+	want := []string{"Box", "Box", "Box Live", "Box Reissue", "Box"}
+	for i, title := range want {
+		if i > 0 {
+			err := p.NextCandidate()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if p.Disc.Title != title || p.Disc.Candidate != i%4 {
+			t.Errorf("press %d: candidate %d titled %q, want %d titled %q", i, p.Disc.Candidate, p.Disc.Title, i%4, title)
+		}
+	}
+
+	if err := p.SelectCandidate(4); err == nil {
+		t.Error("selected a candidate the disc does not have")
+	}
+
+	// A new lookup keeps the release chosen before.
+	p.SelectCandidate(3)
+	p.Disc.SetCandidates(slices.Clone(p.Disc.Candidates))
+	if p.Disc.Candidate != 3 || p.Disc.Title != "Box Reissue" {
+		t.Errorf("after a new lookup candidate %d titled %q, want the reissue", p.Disc.Candidate, p.Disc.Title)
+	}
+}
+
+func TestLengthScore(t *testing.T) {
+	tracks := func(lengths ...int) []MBTrack {
+		var tracks []MBTrack
+		for _, length := range lengths {
+			tracks = append(tracks, MBTrack{Length: length})
+		}
+		return tracks
+	}
+	disc := []int{180000, 240000}
+
+	tests := []struct {
+		name   string
+		tracks []MBTrack
+		want   float64
+	}{
+		{"identical", tracks(180000, 240000), 1},
+		{"near miss", tracks(182000, 239000), 0.85},
+		{"one track off by the threshold", tracks(180000, 250000), 0.5},
+		{"past the threshold", tracks(200000, 260000), 0},
+		{"unknown lengths", tracks(0, 0), 0.5},
+		{"one length unknown", tracks(0, 241000), 0.9},
+		{"different track count", tracks(180000), 0},
+	}
+
+	for _, test := range tests {
+		if got := lengthScore(disc, test.tracks); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: lengthScore = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// fuzzyDiscs is a TOC lookup: two releases whose lengths come close to the
+// disc's, one far off and one with another track count.
+const fuzzyDiscs = `{"id": "-", "releases": [
+	{"id": "close", "title": "Close", "media": [{"position": 1, "format": "CD", "tracks": [{"title": "1", "length": 181000}, {"title": "2", "length": 240000}]}]},
+	{"id": "closer", "title": "Closer", "media": [{"position": 1, "format": "CD", "tracks": [{"title": "1", "length": 180000}, {"title": "2", "length": 240500}]}]},
+	{"id": "far", "title": "Far", "media": [{"position": 1, "format": "CD", "tracks": [{"title": "1", "length": 150000}, {"title": "2", "length": 300000}]}]},
+	{"id": "longer", "title": "Longer", "media": [{"position": 1, "format": "CD", "tracks": [{"title": "1", "length": 180000}, {"title": "2", "length": 240000}, {"title": "3", "length": 60000}]}]}
+]}`
+
+func TestFuzzyReleaseCandidates(t *testing.T) {
+	query := &DiscQuery{ID: "unknown", Tracks: 2, Lengths: []int{180000, 240000}}
+	candidates := sortCandidates(releaseCandidates("musicbrainz", DefaultConfig().MusicBrainz, query, parseDiscIDResponse(t, fuzzyDiscs)))
+
+	var got []string
+	for _, candidate := range candidates {
+		got = append(got, candidate.ReleaseID)
+		if !candidate.Approximate {
+			t.Errorf("%s found by TOC is not approximate", candidate.ReleaseID)
+		}
+		if candidate.Confidence > 0.5 {
+			t.Errorf("%s found by TOC has confidence %v", candidate.ReleaseID, candidate.Confidence)
+		}
+	}
+	if want := []string{"closer", "close"}; !slices.Equal(got, want) {
+		t.Errorf("candidates = %v, want %v", got, want)
+	}
+}
+
+func TestCDStubCandidate(t *testing.T) {
+	stub := `{"id": "stub", "title": "Demo", "artist": "Band", "tracks": [
+		{"title": "One", "length": 180000}, {"title": "Two", "artist": "Guest", "length": 241000}]}`
+
+	query := &DiscQuery{ID: "stub", Tracks: 2, Lengths: []int{180000, 240000}}
+	candidates := releaseCandidates("musicbrainz", DefaultConfig().MusicBrainz, query, parseDiscIDResponse(t, stub))
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates for a CD stub, want 1", len(candidates))
+	}
+
+	candidate := candidates[0]
+	if !candidate.Approximate || candidate.Format != "CD stub" || math.Abs(candidate.Confidence-0.3*0.95) > 1e-9 {
+		t.Errorf("stub candidate = %+v, want an approximate CD stub with confidence 0.285", candidate)
+	}
+	if candidate.Tracks[1].Artist != "Guest" || candidate.Tracks[0].Artist != "" {
+		t.Errorf("stub track artists %q and %q, want only the guest", candidate.Tracks[0].Artist, candidate.Tracks[1].Artist)
+	}
+
+	query.Tracks = 3
+	if candidates := releaseCandidates("musicbrainz", DefaultConfig().MusicBrainz, query, parseDiscIDResponse(t, stub)); len(candidates) != 0 {
+		t.Errorf("CD stub with another track count gave %d candidates", len(candidates))
+	}
+}
+
+func TestApproximateAlbumLine(t *testing.T) {
+	p, _ := newTestPlayer(t, parseTestTOC(t, "1 2 40000 150 20000"))
+	query := &DiscQuery{ID: "unknown", Tracks: 2, Lengths: []int{180000, 240000}}
+	p.Disc.SetCandidates(sortCandidates(releaseCandidates("musicbrainz", DefaultConfig().MusicBrainz, query, parseDiscIDResponse(t, fuzzyDiscs))))
+
+	c := newController(&bufferPort{}, ControllerConfig{Charset: charsetASCII})
+	p.UpdateController(c)
+	if album := c.fields["album"]; album != "~ Closer" {
+		t.Errorf("album line = %q, want it marked approximate", album)
+	}
+}
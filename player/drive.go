@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Drive controls the optical drive through CD-ROM ioctls.
+type Drive struct {
+	Device string
+}
+
+func NewDrive(device string) *Drive {
+	return &Drive{Device: device}
+}
+
+func (d *Drive) ioctl(name string, request uintptr, arg uintptr) (int, error) {
+	fd, err := openDrive(d.Device)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+
+	r, err := cdromIoctl(fd, request, arg)
+	if err != nil {
+		return 0, fmt.Errorf("%s failed: %w", name, err)
+	}
+
+	return r, nil
+}
+
+// Eject unlocks the tray and opens it. The cdrom driver refuses both while
+// the device is open elsewhere, e.g. by mpv, the drive is then told
+// directly with SCSI commands like eject(1) does.
+func (d *Drive) Eject() error {
+	err := d.Lock(false)
+	if err == nil {
+		_, err = d.ioctl("CDROMEJECT", CDROMEJECT, 0)
+	}
+	if !errors.Is(err, unix.EBUSY) {
+		return err
+	}
+
+	fmt.Printf("%v, ejecting with SCSI commands\n", err)
+	return d.scsiEject()
+}
+
+// scsiEject allows medium removal and stops the disc with the eject bit
+// set, see MMC-3 6.1.28 and SPC-3 6.13.
+func (d *Drive) scsiEject() error {
+	fd, err := unix.Open(d.Device, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", d.Device, err)
+	}
+	defer unix.Close(fd)
+
+	allow := []byte{0x1e, 0, 0, 0, 0, 0} // PREVENT ALLOW MEDIUM REMOVAL
+	err = scsiCommand(fd, allow, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to unlock tray: %w", err)
+	}
+
+	eject := []byte{0x1b, 0, 0, 0, 0x02, 0} // START STOP UNIT, LoEj without Start
+	err = scsiCommand(fd, eject, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to eject: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Drive) CloseTray() error {
+	_, err := d.ioctl("CDROMCLOSETRAY", CDROMCLOSETRAY, 0)
+	return err
+}
+
+// Lock keeps the tray closed, the drive's eject button does nothing while
+// the tray is locked.
+func (d *Drive) Lock(locked bool) error {
+	var arg uintptr
+	if locked {
+		arg = 1
+	}
+
+	_, err := d.ioctl("CDROM_LOCKDOOR", CDROM_LOCKDOOR, arg)
+	return err
+}
+
+// SelectSpeed sets the read speed as a multiple of 1x audio speed, 0 asks
+// for the drive's maximum. Lower speeds keep the drive quiet.
+func (d *Drive) SelectSpeed(speed int) error {
+	_, err := d.ioctl("CDROM_SELECT_SPEED", CDROM_SELECT_SPEED, uintptr(speed))
+	return err
+}
+
+func (d *Drive) Status() (DriveStatus, error) {
+	status, err := d.ioctl("CDROM_DRIVE_STATUS", CDROM_DRIVE_STATUS, CDSL_CURRENT)
+	if err != nil {
+		return CDS_NO_INFO, err
+	}
+
+	return DriveStatus(status), nil
+}
@@ -3,8 +3,7 @@ module github.com/danfragoso/oscdp/player
 go 1.21.8
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
-	go.uploadedlobster.com/discid v0.7.0
+	golang.org/x/sys v0.24.0
 )
-
-require golang.org/x/sys v0.24.0 // indirect
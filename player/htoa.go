@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// htoaMinFrames is the shortest pregap taken for a hidden track,
+	// track 1 normally starts after 2 seconds of silence at LBA 0.
+	htoaMinFrames = 75
+
+	// htoaReadSectors is the number of sectors ripped per READ CD, it
+	// keeps a transfer under 64 KiB.
+	htoaReadSectors = 26
+)
+
+// HiddenTrack is the ripped audio of the pregap of a disc's track 1.
+type HiddenTrack struct {
+	DiscID string
+	Path   string
+	Track  *Track // the disc's Hidden, for when it is ripped before the disc is identified
+}
+
+// detectHiddenTrack looks for audio hidden before index 1 of track 1 and
+// adds it to the disc as track 0. The TOC only gives where index 1
+// starts, the Q subchannel at LBA 0 tells whether it is still track 1 in
+// its pregap.
+func detectHiddenTrack(ctx context.Context, device string, disc *Disc) error {
+	if len(disc.Tracks) == 0 || disc.Tracks[0].Number != "1" || disc.Tracks[0].Data {
+		return nil
+	}
+
+	pregap := pregapFrames(disc)
+	if pregap < htoaMinFrames {
+		return nil
+	}
+
+	fd, err := openDrive(device)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	q, err := readSubQ(ctx, fd, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read Q subchannel: %w", err)
+	}
+	if q == nil || q.Track != 1 || q.Index != 0 || q.Control&CDROM_DATA_TRACK != 0 {
+		return nil
+	}
+
+	disc.Hidden = &Track{
+		Title:  "Hidden Track",
+		Number: "0",
+		Offset: 0,
+		Length: pregap * 1000 / 75,
+	}
+
+	return nil
+}
+
+// pregapFrames returns the length of the pregap of track 1, which starts
+// at LBA 0.
+func pregapFrames(disc *Disc) int {
+	_, _, _, offsets, err := parseTOC(disc.TOC)
+	if err != nil || len(offsets) == 0 {
+		return 0
+	}
+
+	return offsets[0] - 150
+}
+
+// ripHiddenTrack copies the pregap of track 1 into a WAV file, mpv plays
+// cdda:// from index 1 of track 1 and cannot reach it.
+func ripHiddenTrack(ctx context.Context, device string, frames int, path string) error {
+	fd, err := openDrive(device)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	_, err = file.Write(wavHeader(frames * cdSectorSize))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+
+	buf := make([]byte, htoaReadSectors*cdSectorSize)
+	for lba := 0; lba < frames; lba += htoaReadSectors {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		sectors := min(htoaReadSectors, frames-lba)
+		n, err := scsiRead(fd, readCDCommand(lba, sectors, 0), buf[:sectors*cdSectorSize], 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to read sector %d: %w", lba, err)
+		}
+
+		_, err = file.Write(buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", tmp, err)
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// wavHeader describes size bytes of CD audio: 44.1 kHz, 16 bit little
+// endian stereo, which is what READ CD returns.
+func wavHeader(size int) []byte {
+	header := make([]byte, 0, 44)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(36+size))
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, 1) // PCM
+	header = binary.LittleEndian.AppendUint16(header, 2)
+	header = binary.LittleEndian.AppendUint32(header, 44100)
+	header = binary.LittleEndian.AppendUint32(header, 44100*4)
+	header = binary.LittleEndian.AppendUint16(header, 4)
+	header = binary.LittleEndian.AppendUint16(header, 16)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(size))
+
+	return header
+}
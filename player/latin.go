@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// latinAliases renames credited artists whose name is not in Latin script
+// after their best Latin alias, preferring primary and English ones.
+func latinAliases(discInfo *DiscIDResponse) {
+	rename := func(credit MBArtistCredit) {
+		for i := range credit {
+			if isLatin(credit[i].Name) {
+				continue
+			}
+
+			best, bestScore := "", -1
+			for _, alias := range credit[i].Artist.Aliases {
+				if alias.Type == "Search hint" || !isLatin(alias.Name) {
+					continue
+				}
+
+				score := 0
+				if alias.Primary {
+					score++
+				}
+				if alias.Locale == "en" {
+					score += 2
+				}
+				if score > bestScore {
+					best, bestScore = alias.Name, score
+				}
+			}
+
+			if best != "" {
+				credit[i].Name = best
+			}
+		}
+	}
+
+	for _, release := range discInfo.Releases {
+		rename(release.ArtistCredit)
+		for _, medium := range release.Media {
+			for _, track := range medium.Tracks {
+				rename(track.ArtistCredit)
+			}
+		}
+	}
+}
+
+// latinCandidate returns the best candidate as its Latin script
+// pseudo-release, nil when it is in Latin script already or has none.
+// Pseudo-releases have no disc IDs, they are found by the "transl-tracklisting"
+// relationship of the release.
+func (m *MusicBrainzProvider) latinCandidate(ctx context.Context, candidate *ReleaseCandidate) (*ReleaseCandidate, error) {
+	latin := isLatin(candidate.Title) && isLatin(candidate.Artist)
+	for _, track := range candidate.Tracks {
+		latin = latin && isLatin(track.Title) && isLatin(track.Artist)
+	}
+	if latin {
+		return nil, nil
+	}
+
+	release, err := m.client.Release(ctx, candidate.ReleaseID, "release-rels")
+	if err != nil {
+		return nil, err
+	}
+
+	pseudoID := ""
+	for _, relation := range release.Relations {
+		if relation.Type == "transl-tracklisting" && relation.Direction == "forward" && relation.Release != nil &&
+			relation.Release.TextRepresentation.Script == "Latn" {
+			pseudoID = relation.Release.ID
+			break
+		}
+	}
+	if pseudoID == "" {
+		return nil, nil
+	}
+
+	pseudo, err := m.client.Release(ctx, pseudoID, "recordings+artist-credits")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, medium := range pseudo.Media {
+		if medium.Position != candidate.Medium || len(medium.Tracks) != len(candidate.Tracks) {
+			continue
+		}
+
+		c := *candidate
+		c.ReleaseID = pseudo.ID
+		c.Title = pseudo.Title
+		c.Artist = pseudo.ArtistCredit.String()
+		c.Disambiguation = pseudo.Disambiguation
+		c.Tracks = make([]TrackMetadata, len(candidate.Tracks))
+		for i, track := range medium.Tracks {
+			c.Tracks[i] = candidate.Tracks[i]
+			c.Tracks[i].Title = track.Title
+			c.Tracks[i].Artist = ""
+			if artist := track.ArtistCredit.String(); artist != c.Artist {
+				c.Tracks[i].Artist = artist
+			}
+		}
+
+		return &c, nil
+	}
+
+	return nil, fmt.Errorf("pseudo-release %s has no medium %d with %d tracks", pseudo.ID, candidate.Medium, len(candidate.Tracks))
+}
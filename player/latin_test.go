@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A Japanese release, its artist has Latin aliases.
+const japaneseDisc = `{"id": "disc", "releases": [{"id": "jp", "title": "音楽図鑑",
+	"artist-credit": [{"name": "坂本龍一", "artist": {"name": "坂本龍一", "aliases": [
+		{"name": "さかもとりゅういち", "locale": "ja", "primary": true},
+		{"name": "Sakamoto Ryuichi", "locale": "ja", "primary": true},
+		{"name": "Ryuichi Sakamoto", "locale": "en", "primary": false},
+		{"name": "Riuichi Sakamoto", "type": "Search hint"}]}}],
+	"media": [{"position": 1, "format": "CD", "discs": [{"id": "disc"}], "tracks": [
+		{"title": "チベタン・ダンス", "artist-credit": [{"name": "坂本龍一", "artist": {"name": "坂本龍一"}}]},
+		{"title": "森の人", "artist-credit": [{"name": "坂本龍一", "artist": {"name": "坂本龍一"}}]}]}]}]}`
+
+const japaneseRelations = `{"id": "jp", "relations": [{"type": "transl-tracklisting", "direction": "forward",
+	"release": {"id": "jp-latn", "text-representation": {"script": "Latn"}}}]}`
+
+const japanesePseudoRelease = `{"id": "jp-latn", "title": "Ongaku Zukan",
+	"artist-credit": [{"name": "Ryuichi Sakamoto"}],
+	"media": [{"position": 1, "tracks": [
+		{"title": "Tibetan Dance", "artist-credit": [{"name": "Ryuichi Sakamoto"}]},
+		{"title": "Mori no Hito", "artist-credit": [{"name": "Ryuichi Sakamoto"}]}]}]}`
+
+// newReleaseServer answers MusicBrainz requests by path and records them.
+func newReleaseServer(t *testing.T, responses map[string]string) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var paths []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+
+		response, ok := responses[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(paths)
+	}
+}
+
+func TestLatinAliases(t *testing.T) {
+	discInfo := parseDiscIDResponse(t, japaneseDisc)
+	latinAliases(discInfo)
+
+	release := discInfo.Releases[0]
+	if artist := release.ArtistCredit.String(); artist != "Ryuichi Sakamoto" {
+		t.Errorf("release artist = %q, want the English alias", artist)
+	}
+	// Tracks credit the artist without aliases.
+	if artist := release.Media[0].Tracks[0].ArtistCredit.String(); artist != "坂本龍一" {
+		t.Errorf("track artist without aliases = %q", artist)
+	}
+}
+
+func TestLatinCandidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]string
+		title     string
+		artist    string
+		release   string
+	}{
+		{
+			name: "pseudo-release",
+			responses: map[string]string{
+				"discid/disc":     japaneseDisc,
+				"release/jp":      japaneseRelations,
+				"release/jp-latn": japanesePseudoRelease,
+			},
+			title:   "Ongaku Zukan",
+			artist:  "Ryuichi Sakamoto",
+			release: "jp-latn",
+		},
+		{
+			// The alias still names the artist, the titles are folded
+			// for the display.
+			name: "alias without pseudo-release",
+			responses: map[string]string{
+				"discid/disc": japaneseDisc,
+				"release/jp":  `{"id": "jp", "relations": []}`,
+			},
+			title:   "音楽図鑑",
+			artist:  "Ryuichi Sakamoto",
+			release: "jp",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newReleaseServer(t, test.responses)
+			provider := NewMusicBrainzProvider(testMusicBrainzConfig(server.URL), true)
+
+			candidates, err := provider.Lookup(context.Background(), &DiscQuery{ID: "disc", Tracks: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			best := candidates[0]
+			if best.ReleaseID != test.release || best.Title != test.title || best.Artist != test.artist {
+				t.Errorf("best candidate %s is %q by %q, want %s, %q by %q", best.ReleaseID, best.Title, best.Artist, test.release, test.title, test.artist)
+			}
+		})
+	}
+}
+
+func TestLatinReleaseNeedsNoPseudoRelease(t *testing.T) {
+	server, paths := newReleaseServer(t, map[string]string{"discid/disc": boxSetDiscs})
+	provider := NewMusicBrainzProvider(testMusicBrainzConfig(server.URL), true)
+
+	_, err := provider.Lookup(context.Background(), &DiscQuery{ID: "disc", Tracks: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := paths(); !slices.Equal(got, []string{"/discid/disc"}) {
+		t.Errorf("requested %v, a Latin release needs no pseudo-release", got)
+	}
+}
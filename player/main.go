@@ -2,31 +2,75 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	config, command, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Failed to load config: %v\n", err)
+		os.Exit(2)
+	}
+
+	if len(command) > 0 {
+		err = runCommand(config, command)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Println("OSCDP (Open Source CD Player)")
 	fmt.Println("2024 - Danilo Fragoso")
 	fmt.Println("--------------")
 
-	controller, err := InitController()
-	if err != nil {
-		fmt.Printf("Failed to initialize controller: %v\n", err)
-		fmt.Println("Continuing without controller support")
+	var controller *Controller
+	if config.Controller.Enabled {
+		controller, err = InitController(config.Controller)
+		if err != nil {
+			fmt.Printf("Failed to initialize controller: %v\n", err)
+			fmt.Println("Continuing without controller support")
+		}
 	}
 
-	mpv, err := InitMPV()
+	supervisor, err := InitMPV(config.MPV)
 	if err != nil {
 		fmt.Printf("Failed to initialize MPV: %v\n", err)
 		return
 	}
 
-	player := InitPlayer(mpv)
+	var cache *MetadataCache
+	if config.Cache.Enabled {
+		cache, err = OpenMetadataCache(config.Cache)
+		if err != nil {
+			fmt.Printf("Failed to open metadata cache: %v\n", err)
+			fmt.Println("Continuing without metadata cache")
+		}
+	}
+
+	var art *CoverArt
+	if config.Art.Enabled {
+		art, err = NewCoverArt(config.Art, config.MusicBrainz)
+		if err != nil {
+			fmt.Printf("Failed to set up cover art: %v\n", err)
+			fmt.Println("Continuing without cover art")
+		}
+	}
 
-	discSize := make(chan int64)
-	go monitorDiscSize(discSize)
+	player := InitPlayer(supervisor.MPV, NewMetadataChain(config, cache), art, supervisor.Dir, config.Drive)
 
-	controllerKeyPresses := make(chan string)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	var drive DriveEventSource = WatchDrive(config.Drive)
+	defer drive.Close()
+
+	transitions := player.State.Subscribe()
+
+	controllerKeyPresses := make(chan *KeyCommand)
 	if controller != nil {
 		fmt.Println("Controller initialized")
 		go controller.ListenKeys(controllerKeyPresses)
@@ -34,31 +78,52 @@ func main() {
 
 	for {
 		select {
-		case size := <-discSize:
-			if player.Disc == nil || player.Disc.Size != size {
-				var err error
-				fmt.Println("Detecting new disc")
-				player.Disc, err = createAndIdentifyDisk(size)
-				if err != nil {
-					player.EjectDisc()
-					continue
-				}
-
-				fmt.Println("New disc detected")
+		case event := <-drive.Events():
+			player.HandleDriveEvent(event)
+		case command := <-controllerKeyPresses:
+			if command.Event == "longpress" {
+				player.HandleLongPress(command.Key)
+			} else {
+				player.HandleKey(command.Key)
+			}
+		case disc := <-player.Identified():
+			if player.ApplyMetadata(disc) {
 				fmt.Println("Artist:", player.Disc.Artist)
 				fmt.Println("Title:", player.Disc.Title)
-
-				if err := player.StartDisc(); err != nil {
-					player.EjectDisc()
+				player.LoadArt()
+			}
+		case indexes := <-player.IndexesRead():
+			if player.ApplyIndexes(indexes) && len(indexes.Indexes) > 0 {
+				fmt.Printf("Index points on %d tracks\n", len(indexes.Indexes))
+			}
+		case artwork := <-player.ArtLoaded():
+			if player.ApplyArt(artwork) && artwork.Source != "" {
+				fmt.Println("Cover:", artwork.Source)
+			}
+		case hidden := <-player.HiddenTrackRipped():
+			if player.ApplyHiddenTrack(hidden) {
+				fmt.Println("Hidden track ready, press Prev on track 1")
+			}
+		case event := <-player.Backend.Events():
+			player.HandleEvent(event)
+		case transition := <-transitions:
+			fmt.Printf("Player state: %v -> %v\n", transition.From, transition.To)
+		case <-supervisor.Restarted:
+			fmt.Println("MPV restarted, restoring playback")
+			if err := player.Restore(); err != nil {
+				fmt.Printf("Failed to restore playback: %v\n", err)
+			}
+		case <-signals:
+			fmt.Println("Shutting down")
+			supervisor.Stop()
+			if cache != nil {
+				if err := cache.Flush(); err != nil {
+					fmt.Printf("Failed to save metadata cache: %v\n", err)
 				}
 			}
-		case key := <-controllerKeyPresses:
-			player.HandleKey(key)
+			return
 		}
 
-		player.UpdatePosition()
-		player.UpdateStatus()
-
 		if controller != nil {
 			player.UpdateController(controller)
 		}
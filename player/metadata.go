@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscQuery is what a MetadataProvider gets to identify a disc with.
+type DiscQuery struct {
+	ID      string
+	TOC     string
+	MCN     string
+	ISRCs   []string // by track, empty when unknown
+	Tracks  int
+	Lengths []int // by track, in ms
+}
+
+type TrackMetadata struct {
+	Title     string `json:"title"`
+	Artist    string `json:"artist,omitempty"`    // when it differs from the release artist
+	Recording string `json:"recording,omitempty"` // MusicBrainz recording ID
+	Length    int    `json:"length,omitempty"`    // as released in ms, the disc's own is in Track
+
+	ClassicalMetadata
+}
+
+// ReleaseCandidate is a release a provider thinks the disc could be. Empty
+// fields are left to other candidates.
+type ReleaseCandidate struct {
+	Provider   string  `json:"provider"`
+	Confidence float64 `json:"confidence"` // 0 to 1
+	Stale      bool    `json:"-"`          // from a cache entry past its TTL
+	Local      bool    `json:"-"`          // read from the disc, not worth caching
+	Cached     bool    `json:"-"`          // served by the cache, Provider found it
+
+	// Approximate candidates were found by TOC rather than disc ID, or
+	// are CD stubs.
+	Approximate bool `json:"approximate,omitempty"`
+
+	ReleaseID string `json:"release_id,omitempty"`
+	Barcode   string `json:"barcode,omitempty"`
+	Medium    int    `json:"medium,omitempty"` // position in the release
+	Country   string `json:"country,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Media     int    `json:"media,omitempty"` // number of media in the release
+
+	Title          string          `json:"title"`
+	Artist         string          `json:"artist"`
+	Year           string          `json:"year,omitempty"`
+	Label          string          `json:"label,omitempty"`
+	CatalogNumber  string          `json:"catalog_number,omitempty"`
+	Disambiguation string          `json:"disambiguation,omitempty"`
+	Tracks         []TrackMetadata `json:"tracks"`
+}
+
+func (r *ReleaseCandidate) String() string {
+	details := []string{r.Provider}
+	for _, detail := range []string{r.Disambiguation, r.Country, r.Format, r.Date, r.Label, r.CatalogNumber} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+	if r.Approximate {
+		details = append(details, "approximate")
+	}
+
+	return fmt.Sprintf("%s - %s (%s)", r.Artist, r.Title, strings.Join(details, ", "))
+}
+
+// Source is what the disc's Sources record for the candidate's fields.
+func (r *ReleaseCandidate) Source() string {
+	if r.Cached {
+		return "cache"
+	}
+
+	return r.Provider
+}
+
+// MetadataProvider looks up release candidates for a disc, returned in the
+// order the provider prefers them.
+type MetadataProvider interface {
+	Name() string
+	Lookup(ctx context.Context, query *DiscQuery) ([]*ReleaseCandidate, error)
+}
+
+// MetadataChain asks its providers in order until the disc is fully
+// identified and merges their candidates field by field, earlier providers
+// and more confident candidates win.
+type MetadataChain struct {
+	providers []MetadataProvider
+	cache     *MetadataCache
+	timeout   time.Duration
+}
+
+var metadataProviders = []string{"overrides", "cache", "musicbrainz", "cddb", "cdtext"}
+
+func NewMetadataChain(config *Config, cache *MetadataCache) *MetadataChain {
+	chain := &MetadataChain{cache: cache, timeout: config.Metadata.Timeout}
+
+	for _, name := range config.Metadata.Providers {
+		switch name {
+		case "overrides":
+			dir, err := overridesDir(config.Metadata)
+			if err != nil {
+				fmt.Printf("Failed to find overrides, ignoring them: %v\n", err)
+				continue
+			}
+			chain.providers = append(chain.providers, NewOverridesProvider(dir))
+		case "cache":
+			if cache != nil {
+				chain.providers = append(chain.providers, cache)
+			}
+		case "musicbrainz":
+			if config.MusicBrainz.Enabled {
+				chain.providers = append(chain.providers, NewMusicBrainzProvider(config.MusicBrainz, config.Controller.Charset != charsetUTF8))
+			}
+		case "cddb":
+			chain.providers = append(chain.providers, NewCDDBProvider(config.CDDB))
+		case "cdtext":
+			chain.providers = append(chain.providers, NewCDTextProvider(config.Drive.Device))
+		}
+	}
+
+	return chain
+}
+
+// NewMetadataChainWith builds a chain from the given providers, e.g. fakes.
+func NewMetadataChainWith(cache *MetadataCache, providers ...MetadataProvider) *MetadataChain {
+	return &MetadataChain{providers: providers, cache: cache, timeout: DefaultConfig().Metadata.Timeout}
+}
+
+func discQuery(disc *Disc) *DiscQuery {
+	query := &DiscQuery{
+		ID:     disc.ID,
+		TOC:    disc.TOC,
+		MCN:    disc.MCN,
+		Tracks: len(disc.Tracks),
+	}
+	for _, track := range disc.Tracks {
+		query.Lengths = append(query.Lengths, track.Length)
+		query.ISRCs = append(query.ISRCs, track.ISRC)
+	}
+
+	return query
+}
+
+// Identify fills in the disc metadata. Results from providers other than
+// the cache are stored in it, stale cache results are used as they are and
+// refreshed in the background. Providers still running when ctx is done or
+// the chain times out are given up on.
+func (c *MetadataChain) Identify(ctx context.Context, disc *Disc) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	query := discQuery(disc)
+
+	var candidates []*ReleaseCandidate
+	cached := false
+	for i, provider := range c.providers {
+		if ctx.Err() != nil {
+			fmt.Printf("Metadata lookup stopped: %v\n", ctx.Err())
+			break
+		}
+
+		found, err := provider.Lookup(ctx, query)
+		if err != nil {
+			fmt.Printf("Metadata provider %s failed: %v\n", provider.Name(), err)
+			continue
+		}
+		candidates = append(candidates, found...)
+
+		// What the cache knows about a disc is as good as it gets until
+		// the entry is refreshed.
+		cached = c.cache != nil && provider == MetadataProvider(c.cache) && len(found) > 0
+		if !cached && !mergeCandidates(disc.Copy(), candidates) {
+			continue
+		}
+
+		if cached && isStale(found) {
+			go c.refresh(disc.Copy(), query, c.providers[i+1:])
+		}
+		break
+	}
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	disc.SetCandidates(candidates)
+	if !cached {
+		c.Store(disc)
+	}
+}
+
+// Store remembers the disc metadata, including the selected candidate, in
+// the cache. Candidates read from the disc itself are left out, they are
+// read again quicker than they are looked up, and a disc only known from
+// them is not cached so it is looked up again when online.
+func (c *MetadataChain) Store(disc *Disc) {
+	if c.cache == nil {
+		return
+	}
+
+	var candidates []*ReleaseCandidate
+	for _, candidate := range disc.Candidates {
+		if !candidate.Local {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	disc = disc.Copy()
+	disc.SetCandidates(candidates)
+
+	err := c.cache.Put(disc)
+	if err != nil {
+		fmt.Printf("Failed to cache disc metadata: %v\n", err)
+	}
+}
+
+func (c *MetadataChain) refresh(disc *Disc, query *DiscQuery, providers []MetadataProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var candidates []*ReleaseCandidate
+	for _, provider := range providers {
+		found, err := provider.Lookup(ctx, query)
+		if err != nil {
+			fmt.Printf("Metadata provider %s failed: %v\n", provider.Name(), err)
+			continue
+		}
+		candidates = append(candidates, found...)
+	}
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	disc.SetCandidates(candidates)
+	c.Store(disc)
+}
+
+func isStale(candidates []*ReleaseCandidate) bool {
+	for _, candidate := range candidates {
+		if candidate.Stale {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeCandidates takes every field from the first candidate that has it.
+// It reports whether every required field could be filled, the year and
+// track artists are optional.
+func mergeCandidates(disc *Disc, candidates []*ReleaseCandidate) bool {
+	disc.Sources = make(map[string]string)
+	disc.Approximate = false
+	complete := true
+
+	set := func(field string, required bool, target *string, value func(*ReleaseCandidate) string) {
+		for _, candidate := range candidates {
+			if v := value(candidate); v != "" {
+				*target = v
+				disc.Sources[field] = candidate.Source()
+				disc.Approximate = disc.Approximate || candidate.Approximate
+				return
+			}
+		}
+		complete = complete && !required
+	}
+
+	set("title", true, &disc.Title, func(r *ReleaseCandidate) string { return r.Title })
+	set("artist", true, &disc.Artist, func(r *ReleaseCandidate) string { return r.Artist })
+	set("year", false, &disc.Year, func(r *ReleaseCandidate) string { return r.Year })
+	set("label", false, &disc.Label, func(r *ReleaseCandidate) string { return r.Label })
+	set("catalog_number", false, &disc.CatalogNumber, func(r *ReleaseCandidate) string { return r.CatalogNumber })
+	set("disambiguation", false, &disc.Disambiguation, func(r *ReleaseCandidate) string { return r.Disambiguation })
+
+	disc.Media = 0
+	for _, candidate := range candidates {
+		if candidate.Media > 0 {
+			disc.Media = candidate.Media
+			break
+		}
+	}
+
+	for i, track := range disc.Tracks {
+		trackMetadata := func(r *ReleaseCandidate) TrackMetadata {
+			if i < len(r.Tracks) {
+				return r.Tracks[i]
+			}
+			return TrackMetadata{}
+		}
+
+		field := "track." + strconv.Itoa(i+1)
+		set(field+".title", true, &track.Title, func(r *ReleaseCandidate) string { return trackMetadata(r).Title })
+		set(field+".artist", false, &track.Artist, func(r *ReleaseCandidate) string { return trackMetadata(r).Artist })
+		set(field+".recording", false, &track.Recording, func(r *ReleaseCandidate) string { return trackMetadata(r).Recording })
+
+		track.ClassicalMetadata = ClassicalMetadata{}
+		for _, candidate := range candidates {
+			if classical := trackMetadata(candidate).ClassicalMetadata; !classical.IsZero() {
+				track.ClassicalMetadata = classical
+				disc.Sources[field+".work"] = candidate.Source()
+				break
+			}
+		}
+	}
+
+	return complete
+}
+
+// SetCandidates fills in the disc metadata from the candidates, which are
+// expected in order of preference. A release selected before stays selected
+// when it is still a candidate.
+func (d *Disc) SetCandidates(candidates []*ReleaseCandidate) {
+	selected := d.SelectedCandidate()
+
+	d.Candidates = candidates
+	d.Candidate = 0
+	if selected != nil && selected.ReleaseID != "" {
+		for i, candidate := range candidates {
+			if candidate.ReleaseID == selected.ReleaseID && candidate.Medium == selected.Medium {
+				d.Candidate = i
+			}
+		}
+	}
+
+	d.SelectCandidate(d.Candidate)
+}
+
+func (d *Disc) SelectedCandidate() *ReleaseCandidate {
+	if d.Candidate < 0 || d.Candidate >= len(d.Candidates) {
+		return nil
+	}
+
+	return d.Candidates[d.Candidate]
+}
+
+// SelectCandidate shows the given candidate, fields it does not have still
+// come from the other candidates.
+func (d *Disc) SelectCandidate(i int) error {
+	if i < 0 || i >= len(d.Candidates) {
+		return fmt.Errorf("no release candidate %d, the disc has %d", i, len(d.Candidates))
+	}
+
+	ordered := []*ReleaseCandidate{d.Candidates[i]}
+	for j, candidate := range d.Candidates {
+		if j != i {
+			ordered = append(ordered, candidate)
+		}
+	}
+
+	d.Candidate = i
+	mergeCandidates(d, ordered)
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import "context"
+
+// FakeMetadataProvider returns fixed candidates per disc ID, for running the
+// metadata chain without a network or cache.
+type FakeMetadataProvider struct {
+	ProviderName string
+	Candidates   map[string][]*ReleaseCandidate
+	Err          error
+	Queries      []*DiscQuery
+}
+
+var _ MetadataProvider = (*FakeMetadataProvider)(nil)
+
+func NewFakeMetadataProvider(name string) *FakeMetadataProvider {
+	return &FakeMetadataProvider{
+		ProviderName: name,
+		Candidates:   make(map[string][]*ReleaseCandidate),
+	}
+}
+
+func (f *FakeMetadataProvider) Add(discID string, candidate *ReleaseCandidate) {
+	candidate.Provider = f.ProviderName
+	f.Candidates[discID] = append(f.Candidates[discID], candidate)
+}
+
+func (f *FakeMetadataProvider) Name() string {
+	return f.ProviderName
+}
+
+func (f *FakeMetadataProvider) Lookup(ctx context.Context, query *DiscQuery) ([]*ReleaseCandidate, error) {
+	f.Queries = append(f.Queries, query)
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	return f.Candidates[query.ID], nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func testRelease(title string, artist string, tracks ...string) *ReleaseCandidate {
+	candidate := &ReleaseCandidate{Title: title, Artist: artist}
+	for _, track := range tracks {
+		candidate.Tracks = append(candidate.Tracks, TrackMetadata{Title: track})
+	}
+
+	return candidate
+}
+
+func TestChainStopsWhenComplete(t *testing.T) {
+	disc := testDisc(t)
+	disc.ID = "test-disc"
+
+	first := NewFakeMetadataProvider("first")
+	first.Add(disc.ID, testRelease("Album", "Artist", "One", "Two", "Three"))
+	second := NewFakeMetadataProvider("second")
+	second.Add(disc.ID, testRelease("Other Album", "Other Artist", "1", "2", "3"))
+
+	NewMetadataChainWith(nil, first, second).Identify(context.Background(), disc)
+
+	if disc.Title != "Album" || disc.Tracks[0].Title != "One" {
+		t.Errorf("identified as %q with track 1 %q", disc.Title, disc.Tracks[0].Title)
+	}
+	if len(second.Queries) != 0 {
+		t.Errorf("second provider was asked although the first knew everything")
+	}
+}
+
+func TestChainMergesFields(t *testing.T) {
+	disc := testDisc(t)
+	disc.ID = "test-disc"
+
+	// Knows the album but not its tracks.
+	first := NewFakeMetadataProvider("first")
+	first.Add(disc.ID, testRelease("Album", "Artist"))
+	failing := NewFakeMetadataProvider("failing")
+	failing.Err = errors.New("offline")
+	second := NewFakeMetadataProvider("second")
+	release := testRelease("Other Album", "Other Artist", "One", "Two", "Three")
+	release.Year = "1999"
+	second.Add(disc.ID, release)
+
+	NewMetadataChainWith(nil, first, failing, second).Identify(context.Background(), disc)
+
+	tests := []struct {
+		field  string
+		value  string
+		source string
+	}{
+		{"title", disc.Title, "first"},
+		{"artist", disc.Artist, "first"},
+		{"year", disc.Year, "second"},
+		{"track.2.title", disc.Tracks[1].Title, "second"},
+	}
+	want := map[string]string{"title": "Album", "artist": "Artist", "year": "1999", "track.2.title": "Two"}
+
+	for _, test := range tests {
+		if test.value != want[test.field] {
+			t.Errorf("%s = %q, want %q", test.field, test.value, want[test.field])
+		}
+		if disc.Sources[test.field] != test.source {
+			t.Errorf("%s from %q, want %q", test.field, disc.Sources[test.field], test.source)
+		}
+	}
+
+	if len(failing.Queries) != 1 || len(second.Queries) != 1 {
+		t.Errorf("providers after an incomplete one asked %d and %d times, want once", len(failing.Queries), len(second.Queries))
+	}
+}
+
+func TestChainUnknownDisc(t *testing.T) {
+	disc := testDisc(t)
+	disc.ID = "test-disc"
+
+	NewMetadataChainWith(nil, NewFakeMetadataProvider("empty")).Identify(context.Background(), disc)
+
+	if disc.Title != "Unknown Album" || len(disc.Candidates) != 0 {
+		t.Errorf("unknown disc identified as %q with %d candidates", disc.Title, len(disc.Candidates))
+	}
+}
+
+func TestChainStopsWhenCancelled(t *testing.T) {
+	disc := testDisc(t)
+	disc.ID = "test-disc"
+
+	provider := NewFakeMetadataProvider("provider")
+	provider.Add(disc.ID, testRelease("Album", "Artist", "One", "Two", "Three"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NewMetadataChainWith(nil, provider).Identify(ctx, disc)
+
+	if len(provider.Queries) != 0 || disc.Title != "Unknown Album" {
+		t.Errorf("cancelled lookup asked %d times and named the disc %q", len(provider.Queries), disc.Title)
+	}
+}
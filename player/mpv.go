@@ -5,64 +5,166 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
-	"os/exec"
+	"sync"
 	"time"
 )
 
-const mpvSocketPath = "/tmp/oscdp-mpv-ipc"
-
 type MPV struct {
-	conn net.Conn
+	conn    net.Conn
+	timeout time.Duration
+
+	mu        sync.Mutex
+	writeMu   sync.Mutex
+	requestID int
+	observeID int
+	pending   map[int]chan *MPVResponse
+	observers map[int]*mpvObserver
+	closed    bool
+
+	messages chan *MPVResponse
+	events   chan BackendEvent
 }
 
+// MPVResponse is any message received from the IPC socket, either a reply
+// to a command (RequestID set) or an unsolicited event (Event set).
 type MPVResponse struct {
-	Data  any    `json:"data"`
-	Error string `json:"error"`
+	Data      any    `json:"data"`
+	Error     string `json:"error"`
+	RequestID int    `json:"request_id"`
+
+	Event  string `json:"event"`
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
 }
 
-func InitMPV() (*MPV, error) {
-	cmd := exec.Command("mpv", "--idle", "--input-ipc-server="+mpvSocketPath)
-	err := cmd.Start()
-	if err != nil {
-		return nil, err
+type mpvObserver struct {
+	property string
+	values   chan any
+}
+
+type MPVCommand []any
+
+type mpvRequest struct {
+	Command   MPVCommand `json:"command"`
+	RequestID int        `json:"request_id"`
+}
+
+func loadFileCommand(url string) MPVCommand {
+	return MPVCommand{"loadfile", url}
+}
+
+func stopCommand() MPVCommand {
+	return MPVCommand{"stop"}
+}
+
+func addCommand(property string, value any) MPVCommand {
+	return MPVCommand{"add", property, value}
+}
+
+func getPropertyCommand(property string) MPVCommand {
+	return MPVCommand{"get_property", property}
+}
+
+func setPropertyCommand(property string, value any) MPVCommand {
+	return MPVCommand{"set_property", property, value}
+}
+
+func seekCommand(seconds float64) MPVCommand {
+	return MPVCommand{"seek", seconds, "absolute"}
+}
+
+func observePropertyCommand(id int, property string) MPVCommand {
+	return MPVCommand{"observe_property", id, property}
+}
+
+func newMPV(timeout time.Duration) *MPV {
+	return &MPV{
+		timeout:   timeout,
+		closed:    true,
+		pending:   make(map[int]chan *MPVResponse),
+		observers: make(map[int]*mpvObserver),
+		messages:  make(chan *MPVResponse, 16),
+		events:    make(chan BackendEvent, 16),
 	}
+}
 
-	time.Sleep(5 * time.Second)
+// attach points the client at a new IPC connection, e.g. after mpv was
+// restarted, and subscribes the existing observers again.
+func (mpv *MPV) attach(conn net.Conn) error {
+	mpv.mu.Lock()
+	if mpv.conn != nil {
+		mpv.conn.Close()
+	}
+	mpv.conn = conn
+	mpv.closed = false
+	observers := make(map[int]string, len(mpv.observers))
+	for id, observer := range mpv.observers {
+		observers[id] = observer.property
+	}
+	mpv.mu.Unlock()
 
-	conn, err := net.Dial("unix", mpvSocketPath)
-	if err != nil {
-		return nil, err
+	go mpv.readMessages(conn)
+
+	for id, property := range observers {
+		err := mpv.SendSuccessCommand(observePropertyCommand(id, property))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mpv *MPV) Close() error {
+	mpv.mu.Lock()
+	defer mpv.mu.Unlock()
+
+	if mpv.conn == nil {
+		return nil
 	}
 
-	return &MPV{conn: conn}, nil
+	return mpv.conn.Close()
 }
 
 func (mpv *MPV) Stop() error {
-	return mpv.SendSuccessCommand(`{"command": ["stop"]}`)
+	return mpv.SendSuccessCommand(stopCommand())
+}
+
+func (mpv *MPV) NextChapter() error {
+	return mpv.SendSuccessCommand(addCommand("chapter", 1))
 }
 
-func (mpv *MPV) NextTrack() error {
-	return mpv.SendSuccessCommand(`{"command": ["add", "chapter", 1]}`)
+func (mpv *MPV) PreviousChapter() error {
+	return mpv.SendSuccessCommand(addCommand("chapter", -1))
 }
 
-func (mpv *MPV) PreviousTrack() error {
-	return mpv.SendSuccessCommand(`{"command": ["add", "chapter", -1]}`)
+func (mpv *MPV) SetChapter(chapter int) error {
+	return mpv.SendSuccessCommand(setPropertyCommand("chapter", chapter))
 }
 
-func (mpv *MPV) StartDisc() error {
-	return mpv.SendSuccessCommand(`{"command": ["loadfile", "cdda://"]}`)
+func (mpv *MPV) LoadDisc() error {
+	return mpv.SendSuccessCommand(loadFileCommand("cdda://"))
+}
+
+func (mpv *MPV) LoadFile(path string) error {
+	return mpv.SendSuccessCommand(loadFileCommand(path))
+}
+
+func (mpv *MPV) Seek(position int) error {
+	return mpv.SendSuccessCommand(seekCommand(float64(position) / 1000))
 }
 
 func (mpv *MPV) Play() error {
-	return mpv.SendSuccessCommand(`{"command": ["set_property", "pause", false]}`)
+	return mpv.SendSuccessCommand(setPropertyCommand("pause", false))
 }
 
 func (mpv *MPV) Pause() error {
-	return mpv.SendSuccessCommand(`{"command": ["set_property", "pause", true]}`)
+	return mpv.SendSuccessCommand(setPropertyCommand("pause", true))
 }
 
-func (mpv *MPV) GetTimePosition() (int, error) {
-	response, err := mpv.SendCommand(`{"command": ["get_property", "time-pos"]}`)
+func (mpv *MPV) Position() (int, error) {
+	response, err := mpv.SendCommand(getPropertyCommand("time-pos"))
 	if err != nil {
 		return 0, err
 	}
@@ -75,50 +177,246 @@ func (mpv *MPV) GetTimePosition() (int, error) {
 	return int(seconds * 1000), nil
 }
 
-func (mpv *MPV) IsPlaying() (bool, error) {
-	response, err := mpv.SendCommand(`{"command": ["get_property", "pause"]}`)
+func (mpv *MPV) SetVolume(volume int) error {
+	return mpv.SendSuccessCommand(setPropertyCommand("volume", volume))
+}
+
+func (mpv *MPV) Volume() (int, error) {
+	response, err := mpv.SendCommand(getPropertyCommand("volume"))
 	if err != nil {
-		return false, err
+		return 0, err
 	}
 
-	paused, ok := response.Data.(bool)
+	volume, ok := response.Data.(float64)
 	if !ok {
-		return false, fmt.Errorf("unexpected data type for pause: %T", response.Data)
+		return 0, fmt.Errorf("unexpected data type for volume: %T", response.Data)
 	}
 
-	return !paused, nil
+	return int(volume), nil
 }
 
-func (mpv *MPV) SendSuccessCommand(command string) error {
-	response, err := mpv.SendCommand(command)
+func (mpv *MPV) Events() <-chan BackendEvent {
+	return mpv.events
+}
+
+// observe subscribes to the properties the player follows and translates
+// them, together with mpv's own events, into BackendEvents.
+func (mpv *MPV) observe() error {
+	timePos, err := mpv.ObserveProperty("time-pos")
 	if err != nil {
 		return err
 	}
 
-	if response.Error == "success" {
-		return nil
+	pause, err := mpv.ObserveProperty("pause")
+	if err != nil {
+		return err
+	}
+
+	chapter, err := mpv.ObserveProperty("chapter")
+	if err != nil {
+		return err
 	}
 
-	return err
+	go func() {
+		for {
+			select {
+			case value := <-timePos:
+				if seconds, ok := value.(float64); ok {
+					mpv.events <- BackendEvent{Type: PositionChanged, Position: int(seconds * 1000)}
+				}
+
+			case value := <-pause:
+				if paused, ok := value.(bool); ok {
+					mpv.events <- BackendEvent{Type: PauseChanged, Paused: paused}
+				}
+
+			case value := <-chapter:
+				index, ok := value.(float64)
+				if !ok {
+					index = -1
+				}
+				mpv.events <- BackendEvent{Type: ChapterChanged, Chapter: int(index)}
+
+			case message := <-mpv.messages:
+				switch {
+				case message.Event == "file-loaded":
+					mpv.events <- BackendEvent{Type: MediaLoaded}
+				case message.Event == "playback-restart":
+					mpv.events <- BackendEvent{Type: PlaybackRestarted}
+				case message.Event == "end-file" && message.Reason == "eof":
+					mpv.events <- BackendEvent{Type: EndOfMedia}
+				}
+			}
+		}
+	}()
+
+	return nil
 }
 
-func (mpv *MPV) SendCommand(command string) (*MPVResponse, error) {
-	_, err := mpv.conn.Write([]byte(command + "\n"))
+// ObserveProperty subscribes to changes of an mpv property. The returned
+// channel only ever holds the latest value, older values are dropped if the
+// receiver falls behind. A nil value means the property is unavailable.
+func (mpv *MPV) ObserveProperty(property string) (<-chan any, error) {
+	values := make(chan any, 1)
+
+	mpv.mu.Lock()
+	mpv.observeID++
+	id := mpv.observeID
+	mpv.observers[id] = &mpvObserver{property: property, values: values}
+	mpv.mu.Unlock()
+
+	err := mpv.SendSuccessCommand(observePropertyCommand(id, property))
 	if err != nil {
-		return nil, fmt.Errorf("error sending command to MPV: %v", err)
+		mpv.mu.Lock()
+		delete(mpv.observers, id)
+		mpv.mu.Unlock()
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func (mpv *MPV) SendSuccessCommand(command MPVCommand) error {
+	response, err := mpv.SendCommand(command)
+	if err != nil {
+		return err
+	}
+
+	if response.Error != "success" {
+		return fmt.Errorf("mpv command %v failed: %s", command[0], response.Error)
+	}
+
+	return nil
+}
+
+func (mpv *MPV) SendCommand(command MPVCommand) (*MPVResponse, error) {
+	return mpv.SendCommandTimeout(command, mpv.timeout)
+}
+
+func (mpv *MPV) SendCommandTimeout(command MPVCommand, timeout time.Duration) (*MPVResponse, error) {
+	reply := make(chan *MPVResponse, 1)
+
+	mpv.mu.Lock()
+	if mpv.closed {
+		mpv.mu.Unlock()
+		return nil, fmt.Errorf("error sending command to MPV: connection closed")
 	}
+	mpv.requestID++
+	id := mpv.requestID
+	mpv.pending[id] = reply
+	conn := mpv.conn
+	mpv.mu.Unlock()
 
-	reader := bufio.NewReader(mpv.conn)
-	response, err := reader.ReadString('\n')
+	defer func() {
+		mpv.mu.Lock()
+		delete(mpv.pending, id)
+		mpv.mu.Unlock()
+	}()
+
+	request, err := json.Marshal(mpvRequest{Command: command, RequestID: id})
 	if err != nil {
-		return nil, fmt.Errorf("error reading response from MPV: %v", err)
+		return nil, fmt.Errorf("error encoding command: %v", err)
 	}
 
-	result := new(MPVResponse)
-	err = json.Unmarshal([]byte(response), &result)
+	mpv.writeMu.Lock()
+	_, err = conn.Write(append(request, '\n'))
+	mpv.writeMu.Unlock()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing JSON response: %v", err)
+		return nil, fmt.Errorf("error sending command to MPV: %v", err)
 	}
 
-	return result, nil
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case response, ok := <-reply:
+		if !ok {
+			return nil, fmt.Errorf("error reading response from MPV: connection closed")
+		}
+		return response, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("timed out waiting for MPV response to %v", command[0])
+	}
+}
+
+func (mpv *MPV) readMessages(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		message := new(MPVResponse)
+		err := json.Unmarshal(scanner.Bytes(), message)
+		if err != nil {
+			fmt.Printf("Error parsing MPV message: %v\n", err)
+			continue
+		}
+
+		mpv.dispatch(message)
+	}
+
+	mpv.mu.Lock()
+	defer mpv.mu.Unlock()
+
+	if mpv.conn != conn {
+		return
+	}
+
+	mpv.closed = true
+	for id, reply := range mpv.pending {
+		close(reply)
+		delete(mpv.pending, id)
+	}
+}
+
+// mpvLifecycleEvents are the unsolicited events observe translates. They
+// change the player state, so unlike property changes, of which only the
+// latest value matters, none of them may be dropped.
+var mpvLifecycleEvents = map[string]bool{
+	"file-loaded":      true,
+	"playback-restart": true,
+	"end-file":         true,
+}
+
+func (mpv *MPV) dispatch(message *MPVResponse) {
+	if message.Event != "" && message.Event != "property-change" {
+		// Wait for observe to catch up, without holding mu so commands
+		// can still be sent in the meantime.
+		if mpvLifecycleEvents[message.Event] {
+			mpv.messages <- message
+		}
+		return
+	}
+
+	mpv.mu.Lock()
+	defer mpv.mu.Unlock()
+
+	switch {
+	case message.Event == "property-change":
+		if observer, ok := mpv.observers[message.ID]; ok {
+			sendLatest(observer.values, message.Data)
+		}
+
+	case message.RequestID != 0:
+		if reply, ok := mpv.pending[message.RequestID]; ok {
+			reply <- message
+		}
+	}
+}
+
+func sendLatest(values chan any, value any) {
+	select {
+	case values <- value:
+		return
+	default:
+	}
+
+	select {
+	case <-values:
+	default:
+	}
+
+	select {
+	case values <- value:
+	default:
+	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDispatchKeepsLifecycleEvents(t *testing.T) {
+	mpv := newMPV(time.Second)
+
+	// More events than the buffer holds while nothing reads them.
+	var sent []string
+	for i := 0; i < 2*cap(mpv.messages); i++ {
+		sent = append(sent, "file-loaded", "audio-reconfig", "end-file")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, event := range sent {
+			mpv.dispatch(&MPVResponse{Event: event, Reason: "eof"})
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	// Commands are not held up by the waiting events.
+	mpv.mu.Lock()
+	mpv.mu.Unlock()
+
+	var received []string
+	for len(received) < 4*cap(mpv.messages) {
+		select {
+		case message := <-mpv.messages:
+			received = append(received, message.Event)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d events, want %d", len(received), 4*cap(mpv.messages))
+		}
+	}
+	<-done
+
+	for i, event := range received {
+		want := "file-loaded"
+		if i%2 == 1 {
+			want = "end-file"
+		}
+		if event != want {
+			t.Fatalf("event %d = %s, want %s", i, event, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Override is a hand written correction of a disc's metadata, empty fields
+// are left to the other providers.
+type Override struct {
+	Album  string          `toml:"album"`
+	Artist string          `toml:"artist"`
+	Year   string          `toml:"year"`
+	Tracks []TrackOverride `toml:"tracks"`
+}
+
+type TrackOverride struct {
+	Title  string `toml:"title"`
+	Artist string `toml:"artist"`
+}
+
+// OverridesProvider reads overrides from <dir>/<disc ID>.toml. It should go
+// first so the overrides win over everything else.
+type OverridesProvider struct {
+	dir string
+}
+
+var _ MetadataProvider = (*OverridesProvider)(nil)
+
+func NewOverridesProvider(dir string) *OverridesProvider {
+	return &OverridesProvider{dir: dir}
+}
+
+// overridesDir returns the configured directory or "overrides" in the
+// state directory.
+func overridesDir(config MetadataConfig) (string, error) {
+	if config.OverridesDir != "" {
+		return config.OverridesDir, nil
+	}
+
+	dir, err := stateDir("")
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "overrides"), nil
+}
+
+func (o *OverridesProvider) Name() string {
+	return "overrides"
+}
+
+func (o *OverridesProvider) Path(discID string) string {
+	return filepath.Join(o.dir, discID+".toml")
+}
+
+func (o *OverridesProvider) Load(discID string) (*Override, error) {
+	var override Override
+	_, err := toml.DecodeFile(o.Path(discID), &override)
+	if err != nil {
+		return nil, err
+	}
+
+	return &override, nil
+}
+
+func (o *OverridesProvider) Lookup(ctx context.Context, query *DiscQuery) ([]*ReleaseCandidate, error) {
+	override, err := o.Load(query.ID)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load override: %w", err)
+	}
+
+	err = override.Validate(query.Tracks)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.Path(query.ID), err)
+	}
+
+	candidate := &ReleaseCandidate{
+		Provider:   o.Name(),
+		Confidence: 1,
+		Local:      true,
+		Title:      override.Album,
+		Artist:     override.Artist,
+		Year:       override.Year,
+	}
+	for _, track := range override.Tracks {
+		candidate.Tracks = append(candidate.Tracks, TrackMetadata{Title: track.Title, Artist: track.Artist})
+	}
+
+	return []*ReleaseCandidate{candidate}, nil
+}
+
+// Validate checks that the override lists every track of the disc, or
+// none to only override the disc fields.
+func (o *Override) Validate(tracks int) error {
+	if len(o.Tracks) != 0 && len(o.Tracks) != tracks {
+		return fmt.Errorf("override lists %d tracks, the disc has %d", len(o.Tracks), tracks)
+	}
+
+	return nil
+}
+
+// Template writes an override prefilled with the disc's metadata, fields
+// that only hold placeholders are left empty.
+func (o *OverridesProvider) Template(disc *Disc) error {
+	known := func(field string, value string) string {
+		if disc.Sources[field] == "" {
+			return ""
+		}
+		return value
+	}
+
+	override := Override{
+		Album:  known("title", disc.Title),
+		Artist: known("artist", disc.Artist),
+		Year:   known("year", disc.Year),
+	}
+	for i, track := range disc.Tracks {
+		field := "track." + strconv.Itoa(i+1)
+		override.Tracks = append(override.Tracks, TrackOverride{
+			Title:  known(field+".title", track.Title),
+			Artist: known(field+".artist", track.Artist),
+		})
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Metadata override for disc %s, empty fields are looked up as usual.\n", disc.ID)
+	err := toml.NewEncoder(&buf).Encode(override)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(o.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create overrides directory: %w", err)
+	}
+
+	return os.WriteFile(o.Path(disc.ID), buf.Bytes(), 0644)
+}
@@ -1,51 +1,604 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type Player struct {
-	Disc *Disc
-	MPV  *MPV
+	Disc     *Disc
+	Backend  AudioBackend
+	Drive    *Drive
+	Metadata *MetadataChain
 
 	Position int // in ms
-	Status   string
+	Chapter  int
+	State    *StateMachine
+
+	paused         bool
+	resumePosition int
+	resumePaused   bool
+
+	speed            int
+	lockWhilePlaying bool
+	locked           bool
+
+	identifying     bool
+	identified      chan *Disc
+	indexesRead     chan *DiscIndexes
+	stopIdentifying context.CancelFunc
+
+	// readers counts the goroutines that have the drive open in the
+	// background, the disc cannot be ejected while they do.
+	readers sync.WaitGroup
+
+	Art         *CoverArt
+	artwork     *Artwork
+	artSent     bool
+	artLoaded   chan *Artwork
+	stopArtwork context.CancelFunc
+
+	// The hidden track plays from a file ripped to dir while the disc is
+	// identified.
+	dir           string
+	hiddenTrack   *HiddenTrack
+	hiddenRipped  chan *HiddenTrack
+	playingHidden bool
 }
 
-func ejectDisc() error {
-	return exec.Command("eject", "/dev/cdrom").Run()
+func (p *Player) setState(state PlayerState) {
+	err := p.State.Transition(state)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if p.lockWhilePlaying {
+		locked := p.State.Is(Identifying, Playing, Paused, Seeking)
+		if locked == p.locked {
+			return
+		}
+
+		err = p.Drive.Lock(locked)
+		if err != nil {
+			fmt.Printf("Failed to lock drive: %v\n", err)
+			return
+		}
+		p.locked = locked
+	}
+}
+
+// LoadDisc reads the disc in the drive and starts playing it with
+// placeholder titles, unless it is the disc that is already loaded. The
+// disc is identified in the background, see Identified.
+func (p *Player) LoadDisc() error {
+	if p.Disc != nil {
+		discID, _, err := getDiscIDAndTOC(p.Drive.Device)
+		if err == nil && discID == p.Disc.ID {
+			return nil
+		}
+	}
+
+	if !p.State.Is(NoDisc, Error) {
+		p.Reset()
+	}
+
+	p.setState(Reading)
+	disc, err := readDisc(p.Drive.Device)
+	if err != nil {
+		p.setState(Error)
+		return err
+	}
+	p.Disc = disc
+
+	p.identify(disc.Copy())
+
+	return p.StartDisc()
+}
+
+// identify looks the disc up, then reads its index points, and rips its
+// hidden track in the background. All of it stops when the disc is
+// changed, see cancelIdentify.
+func (p *Player) identify(disc *Disc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopIdentifying = cancel
+	p.identifying = true
+
+	p.readers.Add(1)
+	go func() {
+		defer p.readers.Done()
+
+		err := readDiscCodes(p.Drive.Device, disc)
+		if err != nil {
+			fmt.Printf("Failed to read MCN and ISRCs: %v\n", err)
+		}
+
+		err = detectHiddenTrack(ctx, p.Drive.Device, disc)
+		if err != nil {
+			fmt.Printf("Failed to look for a hidden track: %v\n", err)
+		}
+
+		// Ripping takes a while, so it does not wait for the lookup.
+		if disc.Hidden != nil && p.dir != "" {
+			hidden := *disc.Hidden
+			p.readers.Add(1)
+			go p.ripHiddenTrack(ctx, &HiddenTrack{
+				DiscID: disc.ID,
+				Path:   filepath.Join(p.dir, "hidden-"+disc.ID+".wav"),
+				Track:  &hidden,
+			}, pregapFrames(disc))
+		}
+
+		p.Metadata.Identify(ctx, disc)
+
+		// The disc belongs to the player once sent.
+		scanned := disc.Copy()
+
+		select {
+		case p.identified <- disc:
+		case <-ctx.Done():
+			return
+		}
+
+		indexes, err := readIndexPoints(ctx, p.Drive.Device, scanned)
+		if err != nil {
+			fmt.Printf("Failed to read index points: %v\n", err)
+			return
+		}
+
+		select {
+		case p.indexesRead <- &DiscIndexes{DiscID: scanned.ID, Indexes: indexes}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (p *Player) ripHiddenTrack(ctx context.Context, hidden *HiddenTrack, frames int) {
+	defer p.readers.Done()
+	fmt.Printf("Ripping hidden track of %d seconds\n", hidden.Track.Length/1000)
+
+	err := ripHiddenTrack(ctx, p.Drive.Device, frames, hidden.Path)
+	if err != nil {
+		fmt.Printf("Failed to rip hidden track: %v\n", err)
+		return
+	}
+
+	select {
+	case p.hiddenRipped <- hidden:
+	case <-ctx.Done():
+		os.Remove(hidden.Path)
+	}
+}
+
+// HiddenTrackRipped delivers hidden tracks ready to be played, to be
+// passed to ApplyHiddenTrack.
+func (p *Player) HiddenTrackRipped() <-chan *HiddenTrack {
+	return p.hiddenRipped
+}
+
+// ApplyHiddenTrack makes track 0 playable, unless the disc was changed in
+// the meantime. It may come before the disc's metadata.
+func (p *Player) ApplyHiddenTrack(hidden *HiddenTrack) bool {
+	if p.Disc == nil || p.Disc.ID != hidden.DiscID {
+		os.Remove(hidden.Path)
+		return false
+	}
+
+	p.hiddenTrack = hidden
+	if p.Disc.Hidden == nil {
+		p.Disc.Hidden = hidden.Track
+	}
+
+	return true
+}
+
+// IndexesRead delivers the index points of the disc once it is
+// identified, to be passed to ApplyIndexes.
+func (p *Player) IndexesRead() <-chan *DiscIndexes {
+	return p.indexesRead
+}
+
+// ApplyIndexes gives the tracks their index points, unless the disc was
+// changed in the meantime.
+func (p *Player) ApplyIndexes(indexes *DiscIndexes) bool {
+	if p.Disc == nil || p.Disc.ID != indexes.DiscID {
+		return false
+	}
+
+	for _, track := range p.Disc.Tracks {
+		track.Indexes = indexes.Indexes[track.Number]
+	}
+
+	return true
+}
+
+// Identified delivers discs whose metadata lookup finished, to be passed
+// to ApplyMetadata from the goroutine that owns the player.
+func (p *Player) Identified() <-chan *Disc {
+	return p.identified
+}
+
+// ApplyMetadata takes the metadata of an identified disc, unless the disc
+// was changed in the meantime. It reports whether it was taken.
+func (p *Player) ApplyMetadata(disc *Disc) bool {
+	if p.Disc == nil || p.Disc.ID != disc.ID {
+		return false
+	}
+
+	p.Disc = disc
+	p.identifying = false
+	if p.State.Is(Identifying) {
+		p.setState(Playing)
+	}
+
+	return true
+}
+
+// LoadArt looks for the cover of the disc in the background, see
+// ArtLoaded.
+func (p *Player) LoadArt() {
+	if p.Art == nil || p.Disc == nil {
+		return
+	}
+
+	p.cancelArt()
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopArtwork = cancel
+
+	disc := p.Disc.Copy()
+	go func() {
+		defer cancel()
+
+		artwork, err := p.Art.Load(ctx, disc)
+		if err != nil {
+			fmt.Printf("Failed to load cover: %v\n", err)
+		}
+		if artwork == nil {
+			artwork = &Artwork{DiscID: disc.ID}
+		}
+
+		select {
+		case p.artLoaded <- artwork:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// ArtLoaded delivers covers found by LoadArt, to be passed to ApplyArt.
+func (p *Player) ArtLoaded() <-chan *Artwork {
+	return p.artLoaded
+}
+
+// ApplyArt shows the cover on the controller, unless the disc was changed
+// in the meantime.
+func (p *Player) ApplyArt(artwork *Artwork) bool {
+	if p.Disc == nil || p.Disc.ID != artwork.DiscID {
+		return false
+	}
+
+	p.artwork = artwork
+	p.artSent = false
+
+	return true
+}
+
+func (p *Player) cancelArt() {
+	if p.stopArtwork != nil {
+		p.stopArtwork()
+		p.stopArtwork = nil
+	}
+}
+
+func (p *Player) cancelIdentify() {
+	p.identifying = false
+	if p.stopIdentifying != nil {
+		p.stopIdentifying()
+		p.stopIdentifying = nil
+	}
 }
 
 func (p *Player) StartDisc() error {
-	err := p.MPV.StartDisc()
+	if p.speed > 0 {
+		err := p.Drive.SelectSpeed(p.speed)
+		if err != nil {
+			fmt.Printf("Failed to select drive speed: %v\n", err)
+		}
+	}
+
+	err := p.Backend.LoadDisc()
 	if err != nil {
+		p.setState(Error)
 		return err
 	}
 
-	p.Status = "Playing"
+	p.playingHidden = false
+	p.paused = false
+	p.setState(p.playingState())
 
 	return nil
 }
 
+// Restore reloads the current disc after the backend was restarted, playback
+// resumes at the last known position once the backend reports it as loaded.
+func (p *Player) Restore() error {
+	if p.Disc == nil || !p.State.Is(Identifying, Playing, Paused, Seeking) {
+		return nil
+	}
+
+	p.resumePosition = p.Position
+	p.resumePaused = p.State.Is(Paused)
+
+	// The hidden track resumes from the start of track 1.
+	if p.playingHidden {
+		p.playingHidden = false
+		p.resumePosition = 0
+	}
+
+	err := p.Backend.LoadDisc()
+	if err != nil {
+		p.setState(Error)
+		return err
+	}
+
+	p.setState(Seeking)
+
+	return nil
+}
+
+func (p *Player) HandleEvent(event BackendEvent) {
+	switch event.Type {
+	case PositionChanged:
+		if p.Disc != nil {
+			p.Position = event.Position
+		}
+
+	case PauseChanged:
+		p.paused = event.Paused
+		if p.State.Is(Identifying, Playing, Paused) {
+			p.setState(p.playingState())
+		}
+
+	case ChapterChanged:
+		p.Chapter = event.Chapter
+		p.skipDataTrack()
+
+	case MediaLoaded:
+		if p.resumePosition > 0 {
+			p.Backend.Seek(p.resumePosition)
+			p.resumePosition = 0
+		}
+
+		if p.resumePaused {
+			if p.Backend.Pause() == nil {
+				p.paused = true
+			}
+			p.resumePaused = false
+		}
+
+	case PlaybackRestarted:
+		if p.State.Is(Seeking) {
+			p.setState(p.playingState())
+		}
+
+	case EndOfMedia:
+		if p.playingHidden {
+			p.leaveHiddenTrack()
+			return
+		}
+		if p.Disc != nil {
+			p.setState(Stopped)
+		}
+	}
+}
+
+func (p *Player) playingState() PlayerState {
+	if p.paused {
+		return Paused
+	}
+	if p.identifying {
+		return Identifying
+	}
+
+	return Playing
+}
+
 func (p *Player) PlayPause() {
-	if p.Status == "Playing" {
-		if p.MPV.Pause() == nil {
-			p.Status = "Paused"
+	switch p.State.Current() {
+	case Identifying, Playing:
+		if p.Backend.Pause() == nil {
+			p.paused = true
+			p.setState(Paused)
 		}
-	} else {
-		if p.MPV.Play() == nil {
-			p.Status = "Playing"
+	case Paused:
+		if p.Backend.Play() == nil {
+			p.paused = false
+			p.setState(p.playingState())
 		}
+	case Stopped:
+		p.StartDisc()
 	}
 }
 
+// PreviousTrack and NextTrack step over data tracks, chapters map to
+// every track of the disc. Before the first audio track comes the hidden
+// track, if the disc has one and it is ripped.
 func (p *Player) PreviousTrack() {
-	p.MPV.PreviousTrack()
+	if p.playingHidden {
+		p.Backend.Seek(0)
+		return
+	}
+
+	if p.Disc == nil || p.Chapter < 0 {
+		p.Backend.PreviousChapter()
+		return
+	}
+
+	for chapter := p.Chapter - 1; chapter >= 0; chapter-- {
+		if chapter < len(p.Disc.Tracks) && !p.Disc.Tracks[chapter].Data {
+			p.Backend.SetChapter(chapter)
+			return
+		}
+	}
+
+	if p.hiddenTrack != nil {
+		p.playHiddenTrack()
+		return
+	}
+
+	// Back to the start of the first audio track, also while the hidden
+	// track is still being ripped.
+	if p.Chapter < len(p.Disc.Tracks) && !p.Disc.Tracks[p.Chapter].Data {
+		p.Backend.SetChapter(p.Chapter)
+	}
 }
 
 func (p *Player) NextTrack() {
-	p.MPV.NextTrack()
+	if p.playingHidden {
+		p.leaveHiddenTrack()
+		return
+	}
+
+	if p.Disc == nil || p.Chapter < 0 {
+		p.Backend.NextChapter()
+		return
+	}
+
+	if chapter := p.nextAudioTrack(p.Chapter); chapter >= 0 {
+		p.Backend.SetChapter(chapter)
+	}
+}
+
+func (p *Player) playHiddenTrack() {
+	err := p.Backend.LoadFile(p.hiddenTrack.Path)
+	if err != nil {
+		fmt.Printf("Failed to play hidden track: %v\n", err)
+		return
+	}
+
+	p.playingHidden = true
+	p.paused = false
+	p.Position = 0
+	p.Chapter = -1
+	p.setState(Seeking)
+}
+
+// leaveHiddenTrack goes on with track 1 on the disc.
+func (p *Player) leaveHiddenTrack() {
+	p.playingHidden = false
+	p.Position = 0
+
+	err := p.Backend.LoadDisc()
+	if err != nil {
+		fmt.Printf("Failed to load disc: %v\n", err)
+		p.setState(Error)
+		return
+	}
+
+	p.paused = false
+	p.setState(Seeking)
+}
+
+// NextIndex and PreviousIndex move between the index points of the
+// current track, past its first or last one they move to the previous or
+// next track.
+func (p *Player) NextIndex() {
+	track := p.GetCurrentTrack()
+	if track != nil {
+		if index := p.currentIndex(track); index > 0 && index < len(track.Indexes) {
+			p.Backend.Seek(track.Indexes[index])
+			return
+		}
+	}
+
+	p.NextTrack()
+}
+
+func (p *Player) PreviousIndex() {
+	track := p.GetCurrentTrack()
+	if track != nil {
+		if index := p.currentIndex(track); index > 1 {
+			p.Backend.Seek(track.Indexes[index-2])
+			return
+		}
+	}
+
+	p.PreviousTrack()
+}
+
+// currentIndex returns the index point of the track playback is in, 0 if
+// the track has none.
+func (p *Player) currentIndex(track *Track) int {
+	if len(track.Indexes) == 0 {
+		return 0
+	}
+
+	index := 1
+	for i, start := range track.Indexes {
+		if p.Position >= start {
+			index = i + 1
+		}
+	}
+
+	return index
+}
+
+// nextAudioTrack returns the chapter of the first audio track after the
+// given one, -1 if there is none.
+func (p *Player) nextAudioTrack(chapter int) int {
+	for chapter++; chapter < len(p.Disc.Tracks); chapter++ {
+		if !p.Disc.Tracks[chapter].Data {
+			return chapter
+		}
+	}
+
+	return -1
+}
+
+// skipDataTrack moves on when playback reaches a data track: the data
+// track 1 of a mixed mode CD or the data session of an Enhanced CD, which
+// is not one of the disc's tracks.
+func (p *Player) skipDataTrack() {
+	if p.Disc == nil || p.Chapter < 0 {
+		return
+	}
+	if p.Chapter < len(p.Disc.Tracks) && !p.Disc.Tracks[p.Chapter].Data {
+		return
+	}
+
+	if chapter := p.nextAudioTrack(p.Chapter); chapter >= 0 {
+		p.Backend.SetChapter(chapter)
+		return
+	}
+
+	p.Backend.Stop()
+	p.setState(Stopped)
+}
+
+// HandleDriveEvent loads the disc once the drive is ready and forgets it
+// when it is taken out.
+func (p *Player) HandleDriveEvent(event DriveEvent) {
+	fmt.Println("Drive:", event.Type)
+
+	switch event.Type {
+	case DriveDiscReady:
+		fmt.Println("Detecting new disc")
+		if err := p.LoadDisc(); err != nil {
+			fmt.Printf("Failed to load disc: %v\n", err)
+			p.EjectDisc()
+			return
+		}
+
+		fmt.Println("Disc ID:", p.Disc.ID)
+	case DriveDiscRemoved, DriveTrayOpen, DriveNoDisc:
+		if p.Disc != nil {
+			p.Reset()
+		}
+	}
 }
 
 func (p *Player) HandleKey(key string) {
@@ -61,58 +614,141 @@ func (p *Player) HandleKey(key string) {
 	}
 }
 
+// HandleLongPress gives the keys their second function. The controller has
+// no index keys, so holding Prev or Next moves between index points.
+func (p *Player) HandleLongPress(key string) {
+	switch key {
+	case "Play/Pause":
+		p.NextCandidate()
+	case "Prev":
+		p.PreviousIndex()
+	case "Next":
+		p.NextIndex()
+	case "Eject":
+		p.CloseTray()
+	}
+}
+
+// SelectCandidate shows the disc as another of the releases it could be and
+// remembers the choice for the next time the disc is inserted.
+func (p *Player) SelectCandidate(i int) error {
+	if p.Disc == nil {
+		return fmt.Errorf("no disc loaded")
+	}
+
+	err := p.Disc.SelectCandidate(i)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Release %d/%d: %v\n", i+1, len(p.Disc.Candidates), p.Disc.SelectedCandidate())
+
+	p.Metadata.Store(p.Disc)
+	p.LoadArt()
+
+	return nil
+}
+
+func (p *Player) NextCandidate() error {
+	if p.Disc == nil || len(p.Disc.Candidates) < 2 {
+		return nil
+	}
+
+	return p.SelectCandidate((p.Disc.Candidate + 1) % len(p.Disc.Candidates))
+}
+
 func (p *Player) Reset() {
-	p.MPV.Stop()
+	p.cancelIdentify()
+	p.cancelArt()
+	p.artwork = nil
+	p.artSent = false
+	if p.hiddenTrack != nil {
+		os.Remove(p.hiddenTrack.Path)
+		p.hiddenTrack = nil
+	}
+	p.playingHidden = false
+	p.Backend.Stop()
 	p.Disc = nil
 	p.Position = 0
-	p.Status = "Stopped"
+	p.Chapter = -1
+	p.setState(NoDisc)
 }
 
 func (p *Player) EjectDisc() error {
+	p.setState(Ejecting)
+	p.cancelIdentify()
+	p.Backend.Stop()
+	if !p.waitForReaders(readersTimeout) {
+		fmt.Println("Still reading the disc, ejecting anyway")
+	}
+
+	err := p.Drive.Eject()
+	if err != nil {
+		fmt.Printf("Failed to eject disc: %v\n", err)
+		p.setState(Error)
+		return err
+	}
+	p.locked = false
+
 	p.Reset()
-	return ejectDisc()
+
+	return nil
 }
 
-func (p *Player) GetCurrentTrack() *Track {
-	if p.Disc == nil {
-		return nil
+// readersTimeout is how long EjectDisc waits for cancelled readers, they
+// notice between two reads and a read of the drive can take seconds.
+const readersTimeout = 5 * time.Second
+
+// waitForReaders waits until the background readers closed the drive and
+// reports whether they did in time.
+func (p *Player) waitForReaders(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		p.readers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
+}
 
-	p.UpdatePosition()
+func (p *Player) CloseTray() error {
+	status, err := p.Drive.Status()
+	if err != nil || status != CDS_TRAY_OPEN {
+		return err
+	}
 
-	for _, track := range p.Disc.Tracks {
-		if p.Position >= track.Offset-2500 && p.Position <= track.Offset+track.Length-2500 {
-			return track
-		}
+	err = p.Drive.CloseTray()
+	if err != nil {
+		fmt.Printf("Failed to close tray: %v\n", err)
 	}
 
-	return nil
+	return err
 }
 
-func (p *Player) UpdatePosition() {
+func (p *Player) GetCurrentTrack() *Track {
 	if p.Disc == nil {
-		return
+		return nil
 	}
 
-	pos, err := p.MPV.GetTimePosition()
-	if err != nil {
-		return
+	if p.playingHidden {
+		return p.Disc.Hidden
 	}
 
-	p.Position = pos
-}
-
-func (p *Player) UpdateStatus() {
-	playing, err := p.MPV.IsPlaying()
-	if err != nil {
-		return
+	if p.Chapter >= 0 && p.Chapter < len(p.Disc.Tracks) {
+		return p.Disc.Tracks[p.Chapter]
 	}
 
-	if playing {
-		p.Status = "Playing"
-	} else {
-		p.Status = "Paused"
+	for _, track := range p.Disc.Tracks {
+		if p.Position >= track.Offset && p.Position < track.Offset+track.Length {
+			return track
+		}
 	}
+
+	return nil
 }
 
 func (p *Player) GetPrettyPosition() string {
@@ -143,30 +779,82 @@ func padLeft(n int, width int) string {
 }
 
 func (p *Player) UpdateController(c *Controller) {
+	if !p.artSent {
+		c.SendArt(p.artwork)
+		p.artSent = true
+	}
+
+	// Called after every backend event, most of them position ticks that
+	// change nothing on the display, so only changed lines are sent.
+	c.WriteField("player_status", p.State.Current().String())
+
 	if p.Disc == nil {
-		c.WriteCommand(`player_status|No Disc`)
-		c.WriteCommand(`time|`)
-		c.WriteCommand(`album|`)
-		c.WriteCommand(`artist|`)
-		c.WriteCommand(`track|`)
+		c.WriteField("time", "")
+		c.WriteField("album", "")
+		c.WriteField("artist", "")
+		c.WriteField("track", "")
 		return
 	} else {
-		c.WriteCommand(`player_status|` + p.Status)
-		c.WriteCommand(`album|` + p.Disc.Title)
-		c.WriteCommand(`artist|` + p.Disc.Artist)
-
-		c.WriteCommand(`time|` + p.GetPrettyPosition())
+		album := p.Disc.Title
+		if p.Disc.Approximate {
+			album = "~ " + album
+		}
+		c.WriteField("album", c.Text(album))
 
+		// Tracks of compilations have their own artist, movements of a
+		// classical work show the composer and work instead and the
+		// movement as title. Most songs are the performance of a work too,
+		// one without movements, and keep their artist.
+		artist := p.Disc.Artist
 		track := p.GetCurrentTrack()
+		if track != nil && track.Artist != "" {
+			artist = track.Artist
+		}
+		if track != nil && track.Movement != "" {
+			artist = track.Work
+			if track.Composer != "" {
+				artist = track.Composer + ": " + track.Work
+			}
+		}
+		c.WriteField("artist", c.Text(artist))
+
+		// Tracks with index points show the current one, as in "3.2".
+		position := p.GetPrettyPosition()
+		if track != nil {
+			if index := p.currentIndex(track); index > 0 {
+				position = track.Number + "." + strconv.Itoa(index) + " " + position
+			}
+		}
+		c.WriteField("time", position)
+
 		if track != nil {
-			c.WriteCommand(`track|` + track.Number + ". " + track.Title)
+			title := track.Title
+			if track.Movement != "" {
+				title = track.Movement
+			}
+			c.WriteField("track", track.Number+". "+c.Text(title))
 		}
 	}
 }
 
-func InitPlayer(mpv *MPV) *Player {
+func InitPlayer(backend AudioBackend, metadata *MetadataChain, art *CoverArt, dir string, config DriveConfig) *Player {
 	return &Player{
-		Disc: nil,
-		MPV:  mpv,
+		Disc:     nil,
+		Backend:  backend,
+		Drive:    NewDrive(config.Device),
+		Metadata: metadata,
+		Art:      art,
+		Chapter:  -1,
+		State:    NewStateMachine(),
+
+		identified:  make(chan *Disc),
+		indexesRead: make(chan *DiscIndexes),
+		artLoaded:   make(chan *Artwork),
+
+		dir:          dir,
+		hiddenRipped: make(chan *HiddenTrack),
+
+		speed:            config.Speed,
+		lockWhilePlaying: config.LockWhilePlaying,
 	}
 }
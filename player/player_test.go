@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestPlayer plays the disc with toc on a FakeBackend. Its chapters
+// start where mpv's do, counting from index 1 of track 1.
+func newTestPlayer(t *testing.T, toc *DiscTOC) (*Player, *FakeBackend) {
+	t.Helper()
+
+	var chapters []int
+	for _, track := range toc.Tracks {
+		chapters = append(chapters, (track.Offset-toc.Tracks[0].Offset)*1000/75)
+	}
+
+	backend := NewFakeBackend(chapters)
+	p := InitPlayer(backend, NewMetadataChainWith(nil), nil, "", DriveConfig{})
+	p.Disc = newDisc(toc)
+	p.setState(Reading)
+
+	err := p.StartDisc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleEvents(p, backend)
+
+	return p, backend
+}
+
+// handleEvents passes what the backend reported on to the player, like the
+// main loop does.
+func handleEvents(p *Player, backend *FakeBackend) {
+	for {
+		select {
+		case event := <-backend.Events():
+			p.HandleEvent(event)
+		default:
+			return
+		}
+	}
+}
+
+func parseTestTOC(t *testing.T, TOC string) *DiscTOC {
+	t.Helper()
+
+	first, last, leadout, offsets, err := parseTOC(TOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toc := &DiscTOC{First: first, Last: last, Leadout: leadout}
+	for i, offset := range offsets {
+		toc.Tracks = append(toc.Tracks, TOCTrack{Number: first + i, Offset: offset})
+	}
+
+	return toc
+}
+
+// testTOC is an audio CD of three tracks.
+func testTOC(t *testing.T) *DiscTOC {
+	return parseTestTOC(t, "1 3 60000 150 20000 40000")
+}
+
+func testDisc(t *testing.T) *Disc {
+	return newDisc(testTOC(t))
+}
+
+func TestTrackNavigation(t *testing.T) {
+	p, backend := newTestPlayer(t, testTOC(t))
+	if p.Chapter != 0 || !p.State.Is(Playing) {
+		t.Fatalf("started at chapter %d in state %v", p.Chapter, p.State.Current())
+	}
+
+	steps := []struct {
+		action  func()
+		chapter int
+	}{
+		{p.NextTrack, 1},
+		{p.NextTrack, 2},
+		{p.NextTrack, 2}, // no track after the last one
+		{p.PreviousTrack, 1},
+		{p.PreviousTrack, 0},
+		{p.PreviousTrack, 0},
+	}
+
+	for i, step := range steps {
+		step.action()
+		handleEvents(p, backend)
+
+		if p.Chapter != step.chapter {
+			t.Errorf("step %d: chapter = %d, want %d", i, p.Chapter, step.chapter)
+		}
+		if track := p.GetCurrentTrack(); track == nil || track.Number != p.Disc.Tracks[step.chapter].Number {
+			t.Errorf("step %d: current track = %v, want track %s", i, track, p.Disc.Tracks[step.chapter].Number)
+		}
+	}
+}
+
+func TestDataTracksAreSkipped(t *testing.T) {
+	// A mixed mode CD, its data track comes first.
+	toc := &DiscTOC{First: 1, Last: 3, Leadout: 60000, Tracks: []TOCTrack{
+		{Number: 1, Offset: 150, Data: true},
+		{Number: 2, Offset: 20000},
+		{Number: 3, Offset: 40000},
+	}}
+
+	p, backend := newTestPlayer(t, toc)
+	if p.Chapter != 1 {
+		t.Fatalf("playback started at chapter %d, want the first audio track", p.Chapter)
+	}
+
+	p.PreviousTrack()
+	handleEvents(p, backend)
+	if p.Chapter != 1 {
+		t.Errorf("Prev moved to chapter %d, want to stay on the first audio track", p.Chapter)
+	}
+
+	p.NextTrack()
+	handleEvents(p, backend)
+	if p.Chapter != 2 {
+		t.Errorf("Next moved to chapter %d, want 2", p.Chapter)
+	}
+}
+
+func TestPlayPause(t *testing.T) {
+	p, backend := newTestPlayer(t, testTOC(t))
+
+	p.PlayPause()
+	handleEvents(p, backend)
+	if !backend.Paused || !p.State.Is(Paused) {
+		t.Errorf("after pausing: backend paused %v, state %v", backend.Paused, p.State.Current())
+	}
+
+	p.PlayPause()
+	handleEvents(p, backend)
+	if backend.Paused || !p.State.Is(Playing) {
+		t.Errorf("after resuming: backend paused %v, state %v", backend.Paused, p.State.Current())
+	}
+}
+
+func TestEndOfMediaStops(t *testing.T) {
+	p, backend := newTestPlayer(t, testTOC(t))
+
+	backend.SetChapter(2)
+	backend.NextChapter()
+	handleEvents(p, backend)
+
+	if !p.State.Is(Stopped) {
+		t.Errorf("state = %v after the last track, want Stopped", p.State.Current())
+	}
+
+	p.PlayPause()
+	handleEvents(p, backend)
+	if !p.State.Is(Playing) || p.Chapter != 0 {
+		t.Errorf("Play after the end: state %v at chapter %d, want Playing at 0", p.State.Current(), p.Chapter)
+	}
+}
+
+func TestRestoreResumesPosition(t *testing.T) {
+	p, backend := newTestPlayer(t, testTOC(t))
+
+	position := backend.Chapters[1] + 5000
+	backend.Seek(position)
+	handleEvents(p, backend)
+	p.PlayPause()
+	handleEvents(p, backend)
+
+	// mpv came back after a crash with nothing loaded.
+	backend.Stop()
+	backend.Paused = false
+	p.Restore()
+	handleEvents(p, backend)
+
+	if backend.PositionMs != position || p.Chapter != 1 {
+		t.Errorf("resumed at %d ms, chapter %d, want %d ms in chapter 1", backend.PositionMs, p.Chapter, position)
+	}
+	if !backend.Paused || !p.State.Is(Paused) {
+		t.Errorf("resumed with backend paused %v in state %v, want paused", backend.Paused, p.State.Current())
+	}
+}
+
+func TestIdentifyInBackground(t *testing.T) {
+	disc := testDisc(t)
+	disc.ID = "test-disc"
+
+	provider := NewFakeMetadataProvider("provider")
+	provider.Add(disc.ID, testRelease("Album", "Artist", "One", "Two", "Three"))
+
+	backend := NewFakeBackend(nil)
+	p := InitPlayer(backend, NewMetadataChainWith(nil, provider), nil, "", DriveConfig{})
+	p.Disc = disc
+	p.setState(Reading)
+	p.identify(disc.Copy())
+	p.StartDisc()
+
+	if !p.State.Is(Identifying) {
+		t.Fatalf("state while identifying = %v", p.State.Current())
+	}
+
+	// Pausing meanwhile comes back to Identifying.
+	p.PlayPause()
+	p.PlayPause()
+	if !p.State.Is(Identifying) {
+		t.Errorf("state after pausing and resuming = %v, want Identifying", p.State.Current())
+	}
+
+	if !p.ApplyMetadata(<-p.Identified()) {
+		t.Fatal("metadata of the loaded disc was not applied")
+	}
+	if p.Disc.Title != "Album" || !p.State.Is(Playing) {
+		t.Errorf("identified as %q in state %v, want Album while Playing", p.Disc.Title, p.State.Current())
+	}
+}
+
+func TestPreviousToHiddenTrack(t *testing.T) {
+	p, backend := newTestPlayer(t, testTOC(t))
+	disc := p.Disc
+	disc.ID = "test-disc"
+
+	// Until the hidden track is ripped Prev restarts track 1.
+	backend.Seek(backend.Chapters[0] + 5000)
+	handleEvents(p, backend)
+	p.PreviousTrack()
+	handleEvents(p, backend)
+	if backend.File != "" || p.Chapter != 0 || backend.PositionMs != backend.Chapters[0] {
+		t.Errorf("Prev before the rip played %q at %d ms, chapter %d, want the start of track 1", backend.File, backend.PositionMs, p.Chapter)
+	}
+
+	path := filepath.Join(t.TempDir(), "hidden.wav")
+	hidden := &HiddenTrack{DiscID: disc.ID, Path: path, Track: &Track{Title: "Hidden Track", Number: "0", Length: 30000}}
+	if !p.ApplyHiddenTrack(hidden) {
+		t.Fatal("hidden track of the loaded disc was not applied")
+	}
+
+	p.PreviousTrack()
+	handleEvents(p, backend)
+	if backend.File != path {
+		t.Errorf("Prev after the rip played %q, want the hidden track", backend.File)
+	}
+	if track := p.GetCurrentTrack(); track == nil || track.Number != "0" {
+		t.Errorf("current track = %v, want track 0", track)
+	}
+
+	p.NextTrack()
+	handleEvents(p, backend)
+	if backend.File != "" || p.Chapter != 0 {
+		t.Errorf("Next from the hidden track played %q, chapter %d, want track 1", backend.File, p.Chapter)
+	}
+}
+
+func TestCurrentIndex(t *testing.T) {
+	p, _ := newTestPlayer(t, testTOC(t))
+
+	// Index points are in ms of playback position, from index 1 of track 1.
+	track := &Track{Number: "2", Indexes: []int{260000, 320000, 380000}}
+	plain := &Track{Number: "3"}
+
+	tests := []struct {
+		track    *Track
+		position int
+		want     int
+	}{
+		{track, 255000, 1}, // in the pregap, shown as index 1
+		{track, 260000, 1},
+		{track, 319999, 1},
+		{track, 320000, 2},
+		{track, 379999, 2},
+		{track, 380000, 3},
+		{track, 500000, 3},
+		{plain, 300000, 0},
+	}
+
+	for _, test := range tests {
+		p.Position = test.position
+		if got := p.currentIndex(test.track); got != test.want {
+			t.Errorf("track %s at %d ms: index %d, want %d", test.track.Number, test.position, got, test.want)
+		}
+	}
+}
+
+func TestApplyIndexes(t *testing.T) {
+	p, _ := newTestPlayer(t, testTOC(t))
+	disc := p.Disc
+	disc.ID = "test-disc"
+
+	if p.ApplyIndexes(&DiscIndexes{DiscID: "other-disc", Indexes: map[string][]int{"2": {1000, 2000}}}) {
+		t.Error("index points of another disc were applied")
+	}
+
+	if !p.ApplyIndexes(&DiscIndexes{DiscID: disc.ID, Indexes: map[string][]int{"2": {260000, 320000}}}) {
+		t.Fatal("index points of the loaded disc were not applied")
+	}
+	if indexes := p.Disc.Tracks[1].Indexes; len(indexes) != 2 || indexes[1] != 320000 {
+		t.Errorf("track 2 index points = %v", indexes)
+	}
+	if indexes := p.Disc.Tracks[0].Indexes; indexes != nil {
+		t.Errorf("track 1 without index points got %v", indexes)
+	}
+}
+
+func TestPositionAfterLongPregap(t *testing.T) {
+	// 30 s of hidden track before index 1 of track 1.
+	p, backend := newTestPlayer(t, parseTestTOC(t, "1 3 60000 2400 20000 40000"))
+
+	backend.Seek(70000)
+	handleEvents(p, backend)
+	if position := p.GetPrettyPosition(); position != "01:10/03:54" {
+		t.Errorf("position 70 s into track 1 shown as %q", position)
+	}
+
+	// Without a chapter the track is found by position.
+	p.Chapter = -1
+	tests := []struct {
+		position int
+		track    string
+	}{
+		{0, "1"},
+		{234000, "1"},
+		{backend.Chapters[1], "2"},
+		{backend.Chapters[2] + 1000, "3"},
+	}
+	for _, test := range tests {
+		p.Position = test.position
+		if track := p.GetCurrentTrack(); track == nil || track.Number != test.track {
+			t.Errorf("track at %d ms = %v, want track %s", test.position, track, test.track)
+		}
+	}
+}
+
+func TestIndexNavigation(t *testing.T) {
+	p, backend := newTestPlayer(t, testTOC(t))
+
+	// Track 2 starts 264.666 s into the disc.
+	p.Disc.Tracks[1].Indexes = []int{backend.Chapters[1], 300000, 340000}
+	backend.SetChapter(1)
+	backend.Seek(305000)
+	handleEvents(p, backend)
+
+	steps := []struct {
+		action   func()
+		position int
+		chapter  int
+	}{
+		{p.NextIndex, 340000, 1},
+		{p.PreviousIndex, 300000, 1},
+		{p.PreviousIndex, backend.Chapters[1], 1},
+		{p.PreviousIndex, backend.Chapters[0], 0}, // before index 1 is the previous track
+	}
+	for i, step := range steps {
+		step.action()
+		handleEvents(p, backend)
+
+		if backend.PositionMs != step.position || p.Chapter != step.chapter {
+			t.Errorf("step %d: at %d ms in chapter %d, want %d ms in chapter %d", i, backend.PositionMs, p.Chapter, step.position, step.chapter)
+		}
+	}
+}
+
+func TestWaitForReaders(t *testing.T) {
+	p, _ := newTestPlayer(t, testTOC(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.readers.Add(1)
+	go func() {
+		defer p.readers.Done()
+
+		// A read in progress when cancelled.
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+	}()
+
+	if p.waitForReaders(10 * time.Millisecond) {
+		t.Error("waited for a reader that was not cancelled")
+	}
+
+	cancel()
+	start := time.Now()
+	if !p.waitForReaders(time.Second) {
+		t.Fatal("cancelled reader did not finish in time")
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("returned after %v, before the reader closed the drive", elapsed)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SCSI generic passthrough, see include/scsi/sg.h
+const (
+	SG_IO             = 0x2285
+	SG_DXFER_NONE     = -1
+	SG_DXFER_FROM_DEV = -3
+	SG_INFO_OK_MASK   = 0x1
+
+	sgSenseBufferLen = 32
+)
+
+// sgIOHdr mirrors struct sg_io_hdr.
+type sgIOHdr struct {
+	InterfaceID    int32
+	DxferDirection int32
+	CmdLen         uint8
+	MxSbLen        uint8
+	IovecCount     uint16
+	DxferLen       uint32
+	Dxferp         uintptr
+	Cmdp           uintptr
+	Sbp            uintptr
+	Timeout        uint32
+	Flags          uint32
+	PackID         int32
+	UsrPtr         uintptr
+	Status         uint8
+	MaskedStatus   uint8
+	MsgStatus      uint8
+	SbLenWr        uint8
+	HostStatus     uint16
+	DriverStatus   uint16
+	Resid          int32
+	Duration       uint32
+	Info           uint32
+}
+
+// SCSIError is a command the drive answered with CHECK CONDITION or that
+// failed in the host adapter or driver.
+type SCSIError struct {
+	Command byte
+	Status  uint8
+	Sense   []byte
+}
+
+func (e *SCSIError) Error() string {
+	if len(e.Sense) >= 14 {
+		// Fixed format sense data: key, additional sense code and qualifier.
+		return fmt.Sprintf("SCSI command %#02x failed: sense %x/%02x/%02x", e.Command, e.Sense[2]&0x0f, e.Sense[12], e.Sense[13])
+	}
+
+	return fmt.Sprintf("SCSI command %#02x failed with status %#02x", e.Command, e.Status)
+}
+
+// scsiRead sends a command that reads into buf and returns the number of
+// bytes the drive transferred.
+func scsiRead(fd int, cdb []byte, buf []byte, timeout time.Duration) (int, error) {
+	return sgIO(fd, cdb, SG_DXFER_FROM_DEV, buf, timeout)
+}
+
+// scsiCommand sends a command that transfers no data. Commands that change
+// the drive's state need fd opened for writing.
+func scsiCommand(fd int, cdb []byte, timeout time.Duration) error {
+	_, err := sgIO(fd, cdb, SG_DXFER_NONE, nil, timeout)
+	return err
+}
+
+func sgIO(fd int, cdb []byte, direction int32, buf []byte, timeout time.Duration) (int, error) {
+	sense := make([]byte, sgSenseBufferLen)
+
+	hdr := sgIOHdr{
+		InterfaceID:    'S',
+		DxferDirection: direction,
+		CmdLen:         uint8(len(cdb)),
+		MxSbLen:        uint8(len(sense)),
+		DxferLen:       uint32(len(buf)),
+		Cmdp:           uintptr(unsafe.Pointer(&cdb[0])),
+		Sbp:            uintptr(unsafe.Pointer(&sense[0])),
+		Timeout:        uint32(timeout.Milliseconds()),
+	}
+	if len(buf) > 0 {
+		hdr.Dxferp = uintptr(unsafe.Pointer(&buf[0]))
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), SG_IO, uintptr(unsafe.Pointer(&hdr)))
+	runtime.KeepAlive(cdb)
+	runtime.KeepAlive(buf)
+	runtime.KeepAlive(sense)
+	if errno != 0 {
+		return 0, fmt.Errorf("SG_IO failed: %w", errno)
+	}
+
+	if hdr.Info&SG_INFO_OK_MASK != 0 {
+		return 0, &SCSIError{Command: cdb[0], Status: hdr.Status, Sense: sense[:hdr.SbLenWr]}
+	}
+
+	return len(buf) - int(hdr.Resid), nil
+}
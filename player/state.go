@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+type PlayerState int
+
+const (
+	NoDisc PlayerState = iota
+	Reading
+	Identifying // playing while the disc's metadata is looked up
+	Stopped
+	Playing
+	Paused
+	Seeking
+	Error
+	Ejecting
+)
+
+var stateNames = map[PlayerState]string{
+	NoDisc:      "No Disc",
+	Reading:     "Reading",
+	Identifying: "Identifying",
+	Stopped:     "Stopped",
+	Playing:     "Playing",
+	Paused:      "Paused",
+	Seeking:     "Seeking",
+	Error:       "Error",
+	Ejecting:    "Ejecting",
+}
+
+// String is also what the controller shows as the player status.
+func (s PlayerState) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("PlayerState(%d)", int(s))
+}
+
+// Identifying takes the place of Playing until the lookup is done, playback
+// can be paused, stopped and resumed meanwhile.
+var stateTransitions = map[PlayerState][]PlayerState{
+	NoDisc:      {Reading, Ejecting},
+	Reading:     {Identifying, Stopped, Playing, Error, Ejecting, NoDisc},
+	Identifying: {Playing, Paused, Stopped, Seeking, Error, Ejecting, NoDisc},
+	Stopped:     {Identifying, Playing, Seeking, Error, Ejecting, NoDisc},
+	Playing:     {Paused, Stopped, Seeking, Error, Ejecting, NoDisc},
+	Paused:      {Identifying, Playing, Stopped, Seeking, Error, Ejecting, NoDisc},
+	Seeking:     {Identifying, Playing, Paused, Stopped, Error, Ejecting, NoDisc},
+	Error:       {Reading, Ejecting, NoDisc},
+	Ejecting:    {NoDisc, Error},
+}
+
+func (s PlayerState) CanTransition(to PlayerState) bool {
+	for _, allowed := range stateTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+type StateTransition struct {
+	From PlayerState
+	To   PlayerState
+}
+
+type StateMachine struct {
+	mu          sync.Mutex
+	state       PlayerState
+	subscribers []chan StateTransition
+}
+
+func NewStateMachine() *StateMachine {
+	return &StateMachine{state: NoDisc}
+}
+
+func (m *StateMachine) Current() PlayerState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+func (m *StateMachine) Is(states ...PlayerState) bool {
+	current := m.Current()
+	for _, state := range states {
+		if current == state {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Transition moves to the given state and notifies subscribers. Moving to
+// the current state is a no-op, transitions not listed in stateTransitions
+// are rejected and leave the state untouched.
+func (m *StateMachine) Transition(to PlayerState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := m.state
+	if from == to {
+		return nil
+	}
+
+	if !from.CanTransition(to) {
+		return fmt.Errorf("invalid player state transition: %v -> %v", from, to)
+	}
+
+	m.state = to
+	for _, subscriber := range m.subscribers {
+		select {
+		case subscriber <- StateTransition{From: from, To: to}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns a channel receiving every transition. Slow subscribers
+// miss transitions rather than blocking the player.
+func (m *StateMachine) Subscribe() <-chan StateTransition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transitions := make(chan StateTransition, 16)
+	m.subscribers = append(m.subscribers, transitions)
+
+	return transitions
+}
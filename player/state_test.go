@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+func TestStateTransitions(t *testing.T) {
+	tests := []struct {
+		from PlayerState
+		to   PlayerState
+		ok   bool
+	}{
+		{NoDisc, Reading, true},
+		{NoDisc, Ejecting, true},
+		{NoDisc, Playing, false},
+		{NoDisc, Identifying, false},
+		{Reading, Identifying, true},
+		{Reading, Playing, true},
+		{Reading, Error, true},
+		{Reading, Paused, false},
+		{Identifying, Playing, true},
+		{Identifying, Paused, true},
+		{Identifying, Stopped, true},
+		{Identifying, Reading, false},
+		{Stopped, Playing, true},
+		{Stopped, Identifying, true},
+		{Stopped, Paused, false},
+		{Playing, Paused, true},
+		{Playing, Seeking, true},
+		{Playing, Ejecting, true},
+		{Playing, Reading, false},
+		{Paused, Playing, true},
+		{Paused, Identifying, true},
+		{Seeking, Playing, true},
+		{Seeking, Paused, true},
+		{Seeking, Reading, false},
+		{Error, Reading, true},
+		{Error, Playing, false},
+		{Ejecting, NoDisc, true},
+		{Ejecting, Playing, false},
+	}
+
+	for _, test := range tests {
+		m := &StateMachine{state: test.from}
+		transitions := m.Subscribe()
+
+		err := m.Transition(test.to)
+		if test.ok {
+			if err != nil {
+				t.Errorf("%v -> %v: %v", test.from, test.to, err)
+				continue
+			}
+			if m.Current() != test.to {
+				t.Errorf("%v -> %v: state is %v", test.from, test.to, m.Current())
+			}
+
+			select {
+			case transition := <-transitions:
+				if transition != (StateTransition{From: test.from, To: test.to}) {
+					t.Errorf("%v -> %v: subscriber got %v -> %v", test.from, test.to, transition.From, transition.To)
+				}
+			default:
+				t.Errorf("%v -> %v: subscriber got no transition", test.from, test.to)
+			}
+		} else {
+			if err == nil {
+				t.Errorf("%v -> %v: transition was allowed", test.from, test.to)
+			}
+			if m.Current() != test.from {
+				t.Errorf("%v -> %v: rejected transition changed the state to %v", test.from, test.to, m.Current())
+			}
+			if len(transitions) != 0 {
+				t.Errorf("%v -> %v: subscriber was told about a rejected transition", test.from, test.to)
+			}
+		}
+	}
+}
+
+func TestTransitionToCurrentState(t *testing.T) {
+	m := NewStateMachine()
+	transitions := m.Subscribe()
+
+	err := m.Transition(NoDisc)
+	if err != nil || len(transitions) != 0 {
+		t.Errorf("staying in NoDisc: err %v, %d transitions", err, len(transitions))
+	}
+}
+
+func TestEveryStateHasAName(t *testing.T) {
+	for state := NoDisc; state <= Ejecting; state++ {
+		if _, ok := stateNames[state]; !ok {
+			t.Errorf("state %d has no name", state)
+		}
+		if _, ok := stateTransitions[state]; !ok {
+			t.Errorf("%v has no transitions", state)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// READ SUB-CHANNEL formats, see MMC-3 6.1.29.
+const (
+	subchannelMCN  = 0x02
+	subchannelISRC = 0x03
+)
+
+// READ CD returns raw 2352 byte audio sectors, optionally followed by the
+// 16 bytes of formatted Q subchannel, see MMC-3 6.1.15.
+const (
+	cdSectorSize = 2352
+	subQSize     = 16
+
+	readCDSubQ = 0x02
+)
+
+// SubQ is the position a sector's Q subchannel reports, in frames.
+type SubQ struct {
+	Control  byte
+	Track    int
+	Index    int
+	Relative int // from index 1 of the track, counting down in the pregap
+	Absolute int // LBA
+}
+
+// DiscIndexes are the index points of a disc's tracks, see
+// readIndexPoints.
+type DiscIndexes struct {
+	DiscID  string
+	Indexes map[string][]int
+}
+
+func readSubchannelCommand(format byte, track int, allocation int) []byte {
+	cdb := make([]byte, 10)
+	cdb[0] = 0x42 // READ SUB-CHANNEL
+	cdb[2] = 0x40 // SubQ
+	cdb[3] = format
+	cdb[6] = byte(track)
+	binary.BigEndian.PutUint16(cdb[7:], uint16(allocation))
+
+	return cdb
+}
+
+func readCDCommand(lba int, sectors int, subchannel byte) []byte {
+	cdb := make([]byte, 12)
+	cdb[0] = 0xbe   // READ CD
+	cdb[1] = 1 << 2 // CD-DA sectors only
+	binary.BigEndian.PutUint32(cdb[2:], uint32(lba))
+	cdb[6] = byte(sectors >> 16)
+	cdb[7] = byte(sectors >> 8)
+	cdb[8] = byte(sectors)
+	cdb[9] = 0x10 // user data
+	cdb[10] = subchannel
+
+	return cdb
+}
+
+// readDiscCodes reads the Media Catalog Number of the disc and the ISRC of
+// every track. Codes the disc does not carry are left empty.
+func readDiscCodes(device string, disc *Disc) error {
+	fd, err := openDrive(device)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	disc.MCN, err = readSubchannelCode(fd, subchannelMCN, 0, 13)
+	if err != nil {
+		return fmt.Errorf("failed to read MCN: %w", err)
+	}
+
+	for _, track := range disc.Tracks {
+		if track.Data {
+			continue
+		}
+
+		number, _ := strconv.Atoi(track.Number)
+		track.ISRC, err = readSubchannelCode(fd, subchannelISRC, number, 12)
+		if err != nil {
+			return fmt.Errorf("failed to read ISRC of track %s: %w", track.Number, err)
+		}
+	}
+
+	return nil
+}
+
+// readSubchannelCode returns the MCN or an ISRC, the response carries a
+// valid bit at byte 8 and the code from byte 9.
+func readSubchannelCode(fd int, format byte, track int, length int) (string, error) {
+	buf := make([]byte, 24)
+	n, err := scsiRead(fd, readSubchannelCommand(format, track, len(buf)), buf, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+
+	if n < 9+length || buf[8]&0x80 == 0 {
+		return "", nil
+	}
+
+	code := strings.TrimRight(string(buf[9:9+length]), "\x00 ")
+	if strings.Trim(code, "0") == "" {
+		return "", nil
+	}
+
+	return code, nil
+}
+
+// readSubQ returns the position encoded in the Q subchannel at lba. Some
+// sectors carry the MCN or an ISRC instead, so a few are read and the
+// first with a position is taken. It returns nil if none has one.
+func readSubQ(ctx context.Context, fd int, lba int) (*SubQ, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	const sectors = 4
+	size := cdSectorSize + subQSize
+
+	buf := make([]byte, sectors*size)
+	n, err := scsiRead(fd, readCDCommand(lba, sectors, readCDSubQ), buf, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; (i+1)*size <= n; i++ {
+		q := buf[i*size+cdSectorSize : (i+1)*size]
+		if q[0]&0x0f != 1 {
+			continue
+		}
+
+		return &SubQ{
+			Control:  q[0] >> 4,
+			Track:    fromBCD(q[1]),
+			Index:    fromBCD(q[2]),
+			Relative: msfFrames(q[3], q[4], q[5]),
+			Absolute: msfFrames(q[7], q[8], q[9]) - 150,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// readIndexPoints finds the index points of the audio tracks that have
+// them, by track number, as playback positions like the track offsets.
+// Most tracks have none, which one or two reads near their end tell, the
+// others are binary searched for the start of every index.
+func readIndexPoints(ctx context.Context, device string, disc *Disc) (map[string][]int, error) {
+	first, _, leadout, offsets, err := parseTOC(disc.TOC)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := openDrive(device)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	indexes := map[string][]int{}
+
+	for _, track := range disc.Tracks {
+		if track.Data {
+			continue
+		}
+
+		number, _ := strconv.Atoi(track.Number)
+		i := number - first
+		if i < 0 || i >= len(offsets) {
+			continue
+		}
+
+		end := leadout
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+
+		starts, err := trackIndexes(ctx, fd, number, offsets[i]-150, end-150)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index points of track %s: %w", track.Number, err)
+		}
+
+		if len(starts) > 1 {
+			points := make([]int, len(starts))
+			for j, lba := range starts {
+				points[j] = playbackTime(lba+150, offsets[0])
+			}
+			indexes[track.Number] = points
+		}
+	}
+
+	return indexes, nil
+}
+
+// trackIndexes returns the sectors where index 1, 2, ... of a track
+// between the start and end sectors begin. The pregap of the next track,
+// if it has one, lies before end.
+func trackIndexes(ctx context.Context, fd int, number int, start int, end int) ([]int, error) {
+	const past = 100 // past the last index, in the next track
+
+	indexes := map[int]int{}
+	indexAt := func(lba int) (int, error) {
+		if index, ok := indexes[lba]; ok {
+			return index, nil
+		}
+
+		q, err := readSubQ(ctx, fd, lba)
+		if err != nil {
+			return 0, err
+		}
+		if q == nil {
+			return 0, fmt.Errorf("no position at sector %d", lba)
+		}
+
+		index := q.Index
+		if q.Track != number {
+			index = past
+		}
+		indexes[lba] = index
+
+		return index, nil
+	}
+
+	// The last sector, or the one before a 2 second pregap, is in the
+	// last index of the track.
+	for _, lba := range []int{end - 1, end - 151} {
+		if lba < start {
+			break
+		}
+
+		index, err := indexAt(lba)
+		if err != nil {
+			return nil, err
+		}
+		if index <= 1 {
+			return []int{start}, nil
+		}
+		if index != past {
+			break
+		}
+	}
+
+	starts := []int{start}
+	for next := 2; next < past; next++ {
+		// The first sector in index next or later.
+		lo, hi := starts[len(starts)-1], end
+		for lo < hi {
+			mid := (lo + hi) / 2
+			index, err := indexAt(mid)
+			if err != nil {
+				return nil, err
+			}
+
+			if index >= next {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		if lo >= end {
+			break
+		}
+
+		index, err := indexAt(lo)
+		if err != nil {
+			return nil, err
+		}
+		if index != next {
+			break
+		}
+		starts = append(starts, lo)
+	}
+
+	return starts, nil
+}
+
+func fromBCD(b byte) int {
+	return int(b>>4)*10 + int(b&0x0f)
+}
+
+func msfFrames(minutes byte, seconds byte, frames byte) int {
+	return (fromBCD(minutes)*60+fromBCD(seconds))*75 + fromBCD(frames)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	mpvSocketPoll   = 100 * time.Millisecond
+	mpvStopTimeout  = 2 * time.Second
+	mpvStableUptime = time.Minute
+)
+
+// MPVSupervisor owns the mpv child process. It restarts mpv with backoff
+// when it exits and re-attaches the MPV client to the new IPC socket, so
+// callers can keep using the same *MPV across restarts.
+type MPVSupervisor struct {
+	MPV *MPV
+
+	// Restarted receives a value every time mpv came back after a crash.
+	Restarted chan struct{}
+
+	// Dir is the runtime directory holding the IPC socket, other files
+	// mpv plays go there too.
+	Dir string
+
+	config     MPVConfig
+	socketPath string
+
+	mu       sync.Mutex
+	process  *mpvProcess
+	stopping bool
+}
+
+type mpvProcess struct {
+	cmd     *exec.Cmd
+	started time.Time
+	done    chan struct{}
+	err     error
+}
+
+func InitMPV(config MPVConfig) (*MPVSupervisor, error) {
+	dir, err := runtimeDir(config.RuntimeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &MPVSupervisor{
+		MPV:        newMPV(config.CommandTimeout),
+		Restarted:  make(chan struct{}, 1),
+		Dir:        dir,
+		config:     config,
+		socketPath: filepath.Join(dir, "mpv.sock"),
+	}
+
+	err = s.start()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.MPV.observe()
+	if err != nil {
+		s.Stop()
+		return nil, err
+	}
+
+	go s.supervise()
+
+	return s, nil
+}
+
+// runtimeDir returns a directory only the current user can access. Unless
+// one is configured it prefers $XDG_RUNTIME_DIR over the shared temp
+// directory.
+func runtimeDir(dir string) (string, error) {
+	if dir == "" {
+		base := os.Getenv("XDG_RUNTIME_DIR")
+		name := "oscdp"
+		if base == "" {
+			base = os.TempDir()
+			name = fmt.Sprintf("oscdp-%d", os.Getuid())
+		}
+		dir = filepath.Join(base, name)
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat runtime directory: %w", err)
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("runtime directory %s is not a directory", dir)
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return "", fmt.Errorf("runtime directory %s is owned by another user", dir)
+	}
+
+	if info.Mode().Perm() != 0700 {
+		err = os.Chmod(dir, 0700)
+		if err != nil {
+			return "", fmt.Errorf("failed to restrict runtime directory: %w", err)
+		}
+	}
+
+	return dir, nil
+}
+
+func (s *MPVSupervisor) removeStaleSocket() error {
+	_, err := os.Lstat(s.socketPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	conn, err := net.Dial("unix", s.socketPath)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("mpv socket %s is already in use", s.socketPath)
+	}
+
+	return os.Remove(s.socketPath)
+}
+
+func (s *MPVSupervisor) start() error {
+	err := s.removeStaleSocket()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(s.config.Binary, "--idle", "--input-ipc-server="+s.socketPath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	process := &mpvProcess{cmd: cmd, started: time.Now(), done: make(chan struct{})}
+	go func() {
+		process.err = cmd.Wait()
+		close(process.done)
+	}()
+
+	conn, err := s.waitForSocket(process)
+	if err != nil {
+		cmd.Process.Kill()
+		<-process.done
+		return err
+	}
+
+	err = s.MPV.attach(conn)
+	if err != nil {
+		cmd.Process.Kill()
+		<-process.done
+		return err
+	}
+
+	s.mu.Lock()
+	s.process = process
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MPVSupervisor) waitForSocket(process *mpvProcess) (net.Conn, error) {
+	deadline := time.Now().Add(s.config.StartTimeout)
+
+	for {
+		conn, err := net.Dial("unix", s.socketPath)
+		if err == nil {
+			return conn, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("mpv socket did not appear within %v: %w", s.config.StartTimeout, err)
+		}
+
+		select {
+		case <-process.done:
+			return nil, fmt.Errorf("mpv exited during startup: %v", process.err)
+		case <-time.After(mpvSocketPoll):
+		}
+	}
+}
+
+func (s *MPVSupervisor) supervise() {
+	backoff := s.config.MinBackoff
+
+	for {
+		s.mu.Lock()
+		process := s.process
+		s.mu.Unlock()
+
+		<-process.done
+
+		s.mu.Lock()
+		stopping := s.stopping
+		s.mu.Unlock()
+		if stopping {
+			return
+		}
+
+		fmt.Printf("MPV exited: %v\n", process.err)
+		if time.Since(process.started) > mpvStableUptime {
+			backoff = s.config.MinBackoff
+		}
+
+		for {
+			fmt.Printf("Restarting MPV in %v\n", backoff)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, s.config.MaxBackoff)
+
+			s.mu.Lock()
+			stopping = s.stopping
+			s.mu.Unlock()
+			if stopping {
+				return
+			}
+
+			err := s.start()
+			if err == nil {
+				break
+			}
+
+			fmt.Printf("Failed to restart MPV: %v\n", err)
+		}
+
+		select {
+		case s.Restarted <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stop terminates mpv and stops restarting it.
+func (s *MPVSupervisor) Stop() {
+	s.mu.Lock()
+	s.stopping = true
+	process := s.process
+	s.mu.Unlock()
+
+	s.MPV.Close()
+
+	if process != nil {
+		process.cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-process.done:
+		case <-time.After(mpvStopTimeout):
+			process.cmd.Process.Kill()
+			<-process.done
+		}
+	}
+
+	os.Remove(s.socketPath)
+}
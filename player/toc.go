@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// An Enhanced CD has its data track in a second session, the gap between
+// the sessions is 11400 frames.
+const sessionGap = 11400
+
+// cdromTocHdr mirrors struct cdrom_tochdr.
+type cdromTocHdr struct {
+	Trk0 uint8
+	Trk1 uint8
+}
+
+// cdromTocEntry mirrors struct cdrom_tocentry, with the address as LBA.
+type cdromTocEntry struct {
+	Track    uint8
+	AdrCtrl  uint8 // adr in the low nibble, ctrl in the high one
+	Format   uint8
+	_        uint8
+	Addr     int32
+	DataMode uint8
+	_        [3]uint8
+}
+
+// cdromMultisession mirrors struct cdrom_multisession.
+type cdromMultisession struct {
+	Addr       int32
+	XAFlag     uint8
+	AddrFormat uint8
+	_          [2]uint8
+}
+
+// DiscTOC is the table of contents as the drive reports it, offsets are
+// in frames and include the 150 frame pregap of the first track.
+type DiscTOC struct {
+	First   int
+	Last    int
+	Leadout int
+	Tracks  []TOCTrack
+
+	// LastSession is the offset the last session starts at, 0 on single
+	// session discs.
+	LastSession int
+}
+
+type TOCTrack struct {
+	Number int
+	Offset int
+	Data   bool
+}
+
+func readTOC(device string) (*DiscTOC, error) {
+	fd, err := openDrive(device)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	var hdr cdromTocHdr
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), CDROMREADTOCHDR, uintptr(unsafe.Pointer(&hdr)))
+	if errno != 0 {
+		return nil, fmt.Errorf("CDROMREADTOCHDR failed: %w", errno)
+	}
+
+	toc := &DiscTOC{First: int(hdr.Trk0), Last: int(hdr.Trk1)}
+	if toc.First < 1 || toc.Last < toc.First || toc.Last > 99 {
+		return nil, fmt.Errorf("invalid TOC, tracks %d to %d", toc.First, toc.Last)
+	}
+
+	for track := toc.First; track <= toc.Last+1; track++ {
+		entry := cdromTocEntry{Track: uint8(track), Format: CDROM_LBA}
+		if track > toc.Last {
+			entry.Track = CDROM_LEADOUT
+		}
+
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), CDROMREADTOCENTRY, uintptr(unsafe.Pointer(&entry)))
+		if errno != 0 {
+			return nil, fmt.Errorf("CDROMREADTOCENTRY %d failed: %w", entry.Track, errno)
+		}
+
+		offset := int(entry.Addr) + 150
+		if track > toc.Last {
+			toc.Leadout = offset
+			break
+		}
+
+		toc.Tracks = append(toc.Tracks, TOCTrack{
+			Number: track,
+			Offset: offset,
+			Data:   (entry.AdrCtrl>>4)&CDROM_DATA_TRACK != 0,
+		})
+	}
+
+	// Drives that cannot tell are taken to hold a single session disc.
+	session := cdromMultisession{AddrFormat: CDROM_LBA}
+	_, _, errno = unix.Syscall(unix.SYS_IOCTL, uintptr(fd), CDROMMULTISESSION, uintptr(unsafe.Pointer(&session)))
+	if errno != 0 {
+		fmt.Printf("CDROMMULTISESSION failed, assuming a single session: %v\n", errno)
+	} else if session.XAFlag != 0 && session.Addr > 0 {
+		toc.LastSession = int(session.Addr) + 150
+	}
+
+	return toc, nil
+}
+
+// audioEnd returns the last track of the disc ID and where its audio ends.
+// Like libdiscid, a data track at the end of a disc with audio tracks is
+// left out and the audio ends one session gap before it.
+func (t *DiscTOC) audioEnd() (last int, leadout int) {
+	if n := len(t.Tracks); n > 1 && t.Tracks[n-1].Data {
+		return t.Last - 1, t.Tracks[n-1].Offset - sessionGap
+	}
+
+	return t.Last, t.Leadout
+}
+
+// String returns the TOC in the "first last leadout offsets..." form
+// libdiscid gives, see audioEnd.
+func (t *DiscTOC) String() string {
+	last, leadout := t.audioEnd()
+
+	parts := []string{strconv.Itoa(t.First), strconv.Itoa(last), strconv.Itoa(leadout)}
+	for _, track := range t.Tracks {
+		if track.Number <= last {
+			parts = append(parts, strconv.Itoa(track.Offset))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,107 @@
+package main
+
+import "testing"
+
+func audioTracks(offsets ...int) []TOCTrack {
+	tracks := make([]TOCTrack, len(offsets))
+	for i, offset := range offsets {
+		tracks[i] = TOCTrack{Number: i + 1, Offset: offset}
+	}
+
+	return tracks
+}
+
+// The IDs are the ones libdiscid computes for the same TOCs, the first two
+// are from the MusicBrainz documentation and the libdiscid tests.
+func TestDiscIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		toc      DiscTOC
+		TOC      string
+		discID   string
+		freedbID string
+	}{
+		{
+			name:     "audio CD",
+			toc:      DiscTOC{First: 1, Last: 6, Leadout: 95462, Tracks: audioTracks(150, 15363, 32314, 46592, 63414, 80489)},
+			TOC:      "1 6 95462 150 15363 32314 46592 63414 80489",
+			discID:   "49HHV7Eb8UKF3aQiNmu1GR8vKTY-",
+			freedbID: "3404f606",
+		},
+		{
+			name: "audio CD with 22 tracks",
+			toc: DiscTOC{First: 1, Last: 22, Leadout: 303602, Tracks: audioTracks(150, 9700, 25887, 39297, 53795, 63735,
+				77517, 94877, 107270, 123552, 135522, 148422, 161197, 174790, 192022, 205545, 218010, 228700, 239590,
+				255470, 266932, 288750)},
+			TOC: "1 22 303602 150 9700 25887 39297 53795 63735 77517 94877 107270 123552 135522 148422 161197 " +
+				"174790 192022 205545 218010 228700 239590 255470 266932 288750",
+			discID:   "xUp1F2NkfP8s8jaeFn_Av3jNEI4-",
+			freedbID: "370fce16",
+		},
+		{
+			name: "Enhanced CD",
+			toc: DiscTOC{First: 1, Last: 5, Leadout: 120000, LastSession: 95000,
+				Tracks: append(audioTracks(150, 20000, 40000, 60000), TOCTrack{Number: 5, Offset: 95000, Data: true})},
+			TOC:      "1 4 83600 150 20000 40000 60000",
+			discID:   "Rmmk99sOw8muHvU98xVG11hLjxA-",
+			freedbID: "23045804",
+		},
+		{
+			name: "Enhanced CD with 11 tracks",
+			toc: DiscTOC{First: 1, Last: 12, Leadout: 290000, LastSession: 252211,
+				Tracks: append(audioTracks(150, 17650, 37420, 58105, 74922, 95837, 117046, 134480, 156320, 176905, 198777),
+					TOCTrack{Number: 12, Offset: 252211, Data: true})},
+			TOC:      "1 11 240811 150 17650 37420 58105 74922 95837 117046 134480 156320 176905 198777",
+			discID:   "oEOZosWHMsHg.tXl.kFg3hkLZ2U-",
+			freedbID: "ab0c880b",
+		},
+		{
+			name: "mixed mode CD",
+			toc: DiscTOC{First: 1, Last: 3, Leadout: 150000,
+				Tracks: []TOCTrack{{Number: 1, Offset: 150, Data: true}, {Number: 2, Offset: 70000}, {Number: 3, Offset: 110000}}},
+			TOC:      "1 3 150000 150 70000 110000",
+			discID:   "lat8F8fuJxEwyzwev06GnSonidg-",
+			freedbID: "2207ce03",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			TOC := test.toc.String()
+			if TOC != test.TOC {
+				t.Fatalf("TOC = %q, want %q", TOC, test.TOC)
+			}
+
+			discID, err := musicBrainzDiscID(TOC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if discID != test.discID {
+				t.Errorf("MusicBrainz disc ID = %s, want %s", discID, test.discID)
+			}
+
+			freedb, err := freedbID(TOC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if freedb != test.freedbID {
+				t.Errorf("freedb ID = %s, want %s", freedb, test.freedbID)
+			}
+		})
+	}
+}
+
+func TestNewDiscEnhancedCD(t *testing.T) {
+	toc := &DiscTOC{First: 1, Last: 3, Leadout: 120000, LastSession: 95000,
+		Tracks: append(audioTracks(150, 40000), TOCTrack{Number: 3, Offset: 95000, Data: true})}
+
+	disc := newDisc(toc)
+	if len(disc.Tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(disc.Tracks))
+	}
+
+	// The last audio track ends a session gap before the data track.
+	if want := (95000 - sessionGap - 40000) * 1000 / 75; disc.Tracks[1].Length != want {
+		t.Errorf("length of track 2 = %d, want %d", disc.Tracks[1].Length, want)
+	}
+}
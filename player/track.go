@@ -2,7 +2,18 @@ package main
 
 type Track struct {
 	Title  string `json:"title"`
+	Artist string `json:"artist,omitempty"` // when it differs from the disc artist
 	Number string `json:"number"`
-	Offset int    `json:"begin"`  // in ms
+	Offset int    `json:"begin"`  // in ms from index 1 of track 1, like the playback position
 	Length int    `json:"length"` // in ms
+	ISRC   string `json:"isrc,omitempty"`
+	Data   bool   `json:"data,omitempty"` // a data track of a mixed mode CD, not played
+
+	// Indexes are the starts of index 1, 2, ... in ms like Offset, only set
+	// when the track has index points.
+	Indexes []int `json:"indexes,omitempty"`
+
+	Recording string `json:"recording,omitempty"` // MusicBrainz recording ID
+
+	ClassicalMetadata
 }
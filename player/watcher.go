@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type DriveEventType int
+
+const (
+	DriveTrayOpen DriveEventType = iota
+	DriveNoDisc
+	DriveDiscReady
+	DriveDiscRemoved
+)
+
+func (t DriveEventType) String() string {
+	switch t {
+	case DriveTrayOpen:
+		return "tray open"
+	case DriveNoDisc:
+		return "no disc"
+	case DriveDiscReady:
+		return "disc ready"
+	case DriveDiscRemoved:
+		return "disc removed"
+	default:
+		return fmt.Sprintf("DriveEventType(%d)", int(t))
+	}
+}
+
+type DriveEvent struct {
+	Type DriveEventType
+}
+
+type DriveEventSource interface {
+	Events() <-chan DriveEvent
+	Close() error
+}
+
+// DriveWatcher turns drive status changes into DriveEvents. It wakes up on
+// kernel uevents for the drive when it can subscribe to them and polls the
+// drive status otherwise. A disc that was swapped for another one is
+// reported as DriveDiscReady again, callers tell discs apart by disc ID.
+type DriveWatcher struct {
+	device   string
+	name     string
+	interval time.Duration
+
+	uevents  int // netlink socket, -1 when polling
+	last     DriveStatus
+	changed  bool
+	settling bool
+	err      error
+
+	events chan DriveEvent
+	done   chan struct{}
+}
+
+func WatchDrive(config DriveConfig) *DriveWatcher {
+	name := config.Device
+	if resolved, err := filepath.EvalSymlinks(config.Device); err == nil {
+		name = resolved
+	}
+
+	w := &DriveWatcher{
+		device:   config.Device,
+		name:     filepath.Base(name),
+		interval: config.PollInterval,
+		uevents:  -1,
+		last:     -1,
+		events:   make(chan DriveEvent, 4),
+		done:     make(chan struct{}),
+	}
+
+	if config.Uevents {
+		fd, err := openUeventSocket()
+		if err != nil {
+			fmt.Printf("Failed to subscribe to uevents, polling the drive instead: %v\n", err)
+		} else {
+			w.uevents = fd
+		}
+	}
+
+	go w.run()
+
+	return w
+}
+
+func (w *DriveWatcher) Events() <-chan DriveEvent {
+	return w.events
+}
+
+func (w *DriveWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+func (w *DriveWatcher) run() {
+	changes := make(chan struct{}, 1)
+	if w.uevents >= 0 {
+		go w.readUevents(changes)
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	w.check()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-changes:
+			w.check()
+		case <-ticker.C:
+			// With uevents the ticker only bridges the time a drive needs
+			// to spin up, no event tells us once it is ready.
+			if w.uevents < 0 || w.last < 0 || w.settling {
+				w.check()
+			}
+		}
+	}
+}
+
+func (w *DriveWatcher) check() {
+	status, changed, err := readDriveStatus(w.device)
+	if err != nil {
+		if w.err == nil || w.err.Error() != err.Error() {
+			fmt.Printf("Failed to read drive status: %v\n", err)
+		}
+		w.err = err
+		return
+	}
+	w.err = nil
+	w.changed = w.changed || changed
+
+	// Nothing to report while the drive is busy, the next check will see
+	// where it ended up.
+	w.settling = status == CDS_DRIVE_NOT_READY || status == CDS_NO_INFO
+	if w.settling {
+		return
+	}
+
+	for _, event := range driveEvents(w.last, status, w.changed) {
+		select {
+		case w.events <- event:
+		case <-w.done:
+			return
+		}
+	}
+
+	w.last = status
+	w.changed = false
+}
+
+func driveEvents(last DriveStatus, status DriveStatus, changed bool) []DriveEvent {
+	var events []DriveEvent
+
+	switch status {
+	case CDS_DISC_OK:
+		if last != CDS_DISC_OK || changed {
+			events = append(events, DriveEvent{Type: DriveDiscReady})
+		}
+
+	case CDS_TRAY_OPEN, CDS_NO_DISC:
+		if last == CDS_DISC_OK {
+			events = append(events, DriveEvent{Type: DriveDiscRemoved})
+		}
+
+		if last != status {
+			if status == CDS_TRAY_OPEN {
+				events = append(events, DriveEvent{Type: DriveTrayOpen})
+			} else {
+				events = append(events, DriveEvent{Type: DriveNoDisc})
+			}
+		}
+	}
+
+	return events
+}
+
+func openUeventSocket() (int, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return -1, err
+	}
+
+	err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1})
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1})
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+func (w *DriveWatcher) readUevents(changes chan struct{}) {
+	defer unix.Close(w.uevents)
+
+	buf := make([]byte, 8192)
+	for {
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(w.uevents, buf, 0)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Failed to read uevent: %v\n", err)
+			time.Sleep(w.interval)
+			continue
+		}
+
+		if isMediaChange(buf[:n], w.name) {
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// isMediaChange reports whether a kernel uevent, a list of NUL separated
+// KEY=value pairs, is a media change or eject request for the device.
+func isMediaChange(message []byte, name string) bool {
+	var device, change bool
+
+	for _, field := range bytes.Split(message, []byte{0}) {
+		switch string(field) {
+		case "DEVNAME=" + name:
+			device = true
+		case "DISK_MEDIA_CHANGE=1", "DISK_EJECT_REQUEST=1":
+			change = true
+		}
+	}
+
+	return device && change
+}
+
+// FakeDriveSource is a DriveEventSource that only reports what it is told
+// to, for running the player without a drive.
+type FakeDriveSource struct {
+	events chan DriveEvent
+}
+
+var _ DriveEventSource = (*FakeDriveSource)(nil)
+
+func NewFakeDriveSource() *FakeDriveSource {
+	return &FakeDriveSource{events: make(chan DriveEvent, 16)}
+}
+
+func (f *FakeDriveSource) Send(eventType DriveEventType) {
+	f.events <- DriveEvent{Type: eventType}
+}
+
+func (f *FakeDriveSource) Events() <-chan DriveEvent {
+	return f.events
+}
+
+func (f *FakeDriveSource) Close() error {
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDriveEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		last    DriveStatus
+		status  DriveStatus
+		changed bool
+		want    []DriveEventType
+	}{
+		{"disc at startup", -1, CDS_DISC_OK, false, []DriveEventType{DriveDiscReady}},
+		{"empty drive at startup", -1, CDS_NO_DISC, false, []DriveEventType{DriveNoDisc}},
+		{"tray opened", CDS_NO_DISC, CDS_TRAY_OPEN, false, []DriveEventType{DriveTrayOpen}},
+		{"disc inserted", CDS_TRAY_OPEN, CDS_DISC_OK, false, []DriveEventType{DriveDiscReady}},
+		{"disc still there", CDS_DISC_OK, CDS_DISC_OK, false, nil},
+		{"disc swapped", CDS_DISC_OK, CDS_DISC_OK, true, []DriveEventType{DriveDiscReady}},
+		{"disc ejected", CDS_DISC_OK, CDS_TRAY_OPEN, false, []DriveEventType{DriveDiscRemoved, DriveTrayOpen}},
+		{"disc gone", CDS_DISC_OK, CDS_NO_DISC, false, []DriveEventType{DriveDiscRemoved, DriveNoDisc}},
+		{"tray still open", CDS_TRAY_OPEN, CDS_TRAY_OPEN, false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []DriveEventType
+			for _, event := range driveEvents(test.last, test.status, test.changed) {
+				got = append(got, event.Type)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("driveEvents(%v, %v, %v) = %v, want %v", test.last, test.status, test.changed, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsMediaChange(t *testing.T) {
+	uevent := func(fields ...string) []byte {
+		var message []byte
+		for _, field := range fields {
+			message = append(message, field...)
+			message = append(message, 0)
+		}
+		return message
+	}
+
+	tests := []struct {
+		name    string
+		message []byte
+		want    bool
+	}{
+		{"media change", uevent("change@/devices/sr0", "ACTION=change", "DEVNAME=sr0", "DISK_MEDIA_CHANGE=1"), true},
+		{"eject request", uevent("change@/devices/sr0", "ACTION=change", "DEVNAME=sr0", "DISK_EJECT_REQUEST=1"), true},
+		{"other device", uevent("change@/devices/sr1", "ACTION=change", "DEVNAME=sr1", "DISK_MEDIA_CHANGE=1"), false},
+		{"other change", uevent("change@/devices/sr0", "ACTION=change", "DEVNAME=sr0"), false},
+	}
+
+	for _, test := range tests {
+		if got := isMediaChange(test.message, "sr0"); got != test.want {
+			t.Errorf("%s: isMediaChange = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDriveEventsResetPlayer(t *testing.T) {
+	source := NewFakeDriveSource()
+	var drive DriveEventSource = source
+	p, backend := newTestPlayer(t, testTOC(t))
+
+	source.Send(DriveNoDisc)
+	p.HandleDriveEvent(<-drive.Events())
+
+	if p.Disc != nil || !p.State.Is(NoDisc) || backend.Loaded {
+		t.Errorf("after the disc was removed: disc %v, state %v, backend loaded %v", p.Disc, p.State.Current(), backend.Loaded)
+	}
+}
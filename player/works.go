@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// ClassicalMetadata describes the work a track is a performance of. Track
+// titles of classical releases pack all of it into one long string.
+type ClassicalMetadata struct {
+	Composer       string `json:"composer,omitempty"`
+	Work           string `json:"work,omitempty"`     // the whole work, e.g. a symphony
+	Movement       string `json:"movement,omitempty"` // the part the track is
+	MovementNumber int    `json:"movement_number,omitempty"`
+	Conductor      string `json:"conductor,omitempty"`
+	Orchestra      string `json:"orchestra,omitempty"`
+}
+
+func (c ClassicalMetadata) IsZero() bool {
+	return c == ClassicalMetadata{}
+}
+
+// MBRelation is a relationship of a recording, work or release to an
+// artist, a work or a release, like "composer", "performance", "parts" or
+// "transl-tracklisting".
+type MBRelation struct {
+	Type        string `json:"type"`
+	TargetType  string `json:"target-type"`
+	Direction   string `json:"direction"`
+	OrderingKey int    `json:"ordering-key"`
+	Artist      *struct {
+		Name string `json:"name"`
+	} `json:"artist"`
+	Work    *MBWork    `json:"work"`
+	Release *MBRelease `json:"release"`
+}
+
+type MBWork struct {
+	ID        string       `json:"id"`
+	Title     string       `json:"title"`
+	Relations []MBRelation `json:"relations"`
+}
+
+// addWorks fills in the classical metadata of a candidate's tracks from
+// the work relationships of its release.
+func (m *MusicBrainzProvider) addWorks(ctx context.Context, candidate *ReleaseCandidate) error {
+	// The relationships of recordings and of the works they are
+	// performances of.
+	release, err := m.client.Release(ctx, candidate.ReleaseID, "recordings+artist-credits+recording-level-rels+work-rels+work-level-rels+artist-rels")
+	if err != nil {
+		return err
+	}
+
+	for _, medium := range release.Media {
+		if medium.Position != candidate.Medium || len(medium.Tracks) != len(candidate.Tracks) {
+			continue
+		}
+
+		for i, track := range medium.Tracks {
+			candidate.Tracks[i].ClassicalMetadata = classicalMetadata(track)
+		}
+	}
+
+	return nil
+}
+
+// classicalMetadata reads the performed work from the relationships of a
+// track's recording. A movement is a work that is part of the whole work,
+// its ordering key is the movement number.
+func classicalMetadata(track MBTrack) ClassicalMetadata {
+	var metadata ClassicalMetadata
+
+	for _, relation := range track.Recording.Relations {
+		switch {
+		case relation.Type == "performance" && relation.Work != nil && metadata.Work == "":
+			work := relation.Work
+			metadata.Work = work.Title
+			metadata.Composer = workComposer(work)
+
+			for _, parent := range work.Relations {
+				if parent.Type != "parts" || parent.Direction != "backward" || parent.Work == nil {
+					continue
+				}
+
+				metadata.Work = parent.Work.Title
+				metadata.MovementNumber = parent.OrderingKey
+				metadata.Movement = movementTitle(work.Title, parent.Work.Title)
+				if metadata.Composer == "" {
+					metadata.Composer = workComposer(parent.Work)
+				}
+				break
+			}
+
+		case relation.Type == "conductor" && relation.Artist != nil:
+			metadata.Conductor = relation.Artist.Name
+
+		case relation.Type == "performing orchestra" && relation.Artist != nil:
+			metadata.Orchestra = relation.Artist.Name
+		}
+	}
+
+	return metadata
+}
+
+func workComposer(work *MBWork) string {
+	for _, relation := range work.Relations {
+		if relation.Type == "composer" && relation.Artist != nil {
+			return relation.Artist.Name
+		}
+	}
+
+	return ""
+}
+
+// movementTitle strips the work title from a movement's, as in "Symphony
+// No. 5 in C minor, Op. 67: I. Allegro con brio".
+func movementTitle(title string, work string) string {
+	if movement, ok := strings.CutPrefix(title, work); ok {
+		if movement = strings.TrimLeft(movement, ":,. "); movement != "" {
+			return movement
+		}
+	}
+
+	if _, movement, ok := strings.Cut(title, ": "); ok {
+		return movement
+	}
+
+	return title
+}